@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 //import "github.com/rgsgit/bank/v2/pkg/types"
 
 //Money the minimal money unit
@@ -8,6 +10,15 @@ type Money int64
 //PaymentCategory пердставляет собой категорию, в которй был соверщен платеж(авто, аптеки, рестораны и т.д)
 type PaymentCategory string
 
+//Category категория из справочника. Code - каноничный код, под которым
+//сохраняются платежи, Aliases - другие написания, которые приводятся к Code.
+type Category struct {
+	Code    PaymentCategory
+	Name    string
+	Parent  PaymentCategory
+	Aliases []string
+}
+
 //PaymentStatus представляет собой статус платежа.
 type PaymentStatus string
 
@@ -16,6 +27,9 @@ const (
 	PaymentStatusOk         PaymentStatus = "OK"
 	PaymentStatusFail       PaymentStatus = "FAIL"
 	PaymentStatusInProgress PaymentStatus = "INPROGRESS"
+
+	PaymentStatusRefunded          PaymentStatus = "REFUNDED"
+	PaymentStatusPartiallyRefunded PaymentStatus = "PARTIALLY_REFUNDED"
 )
 
 //Payment представляет информация о платеже
@@ -25,8 +39,27 @@ type Payment struct {
 	Amount    Money
 	Category  PaymentCategory
 	Status    PaymentStatus
+	//Refunded сколько уже возвращено по платежу
+	Refunded Money
+	//History история смены статусов
+	History []StatusChange
+	//FavoriteID избранное, по которому совершён платёж
+	FavoriteID string
+	Note       string
+	Created    time.Time
+	Updated    time.Time
+}
+
+//StatusChange смена статуса платежа
+type StatusChange struct {
+	From PaymentStatus
+	To   PaymentStatus
+	At   time.Time
 }
 
+//AccountTier тариф счёта (стандартный, премиум и т.д.)
+type AccountTier string
+
 //Phone номер телефона
 type Phone string
 
@@ -35,6 +68,19 @@ type Account struct {
 	ID      int64
 	Phone   Phone
 	Balance Money
+	//OverdraftLimit насколько баланс может уйти в минус
+	OverdraftLimit Money
+	//Held сумма активных блокировок, в журнал не проводится
+	Held Money
+	//Tier тариф счёта, по нему выбираются правила комиссий
+	Tier    AccountTier
+	Created time.Time
+	Updated time.Time
+}
+
+//Available доступный баланс: текущий баланс за вычетом блокировок
+func (a *Account) Available() Money {
+	return a.Balance - a.Held
 }
 
 //Favirite шаблон для создания платежа
@@ -44,9 +90,309 @@ type Favorite struct {
 	Name      string
 	Amount    Money
 	Category  PaymentCategory
+	//Uses сколько раз по шаблону платили
+	Uses    int64
+	Created time.Time
+	Updated time.Time
+}
+
+//FavoriteOverrides значения, заменяющие данные избранного при платеже.
+//Нулевые поля не меняют соответствующие значения избранного.
+type FavoriteOverrides struct {
+	Amount   Money
+	Category PaymentCategory
+	Note     string
+}
+
+//HoldStatus статус блокировки средств
+type HoldStatus string
+
+//Предопределенные статусы блокировки
+const (
+	HoldStatusActive   HoldStatus = "ACTIVE"
+	HoldStatusCaptured HoldStatus = "CAPTURED"
+	HoldStatusVoided   HoldStatus = "VOIDED"
+	HoldStatusExpired  HoldStatus = "EXPIRED"
+)
+
+//Hold блокировка средств до того, как станет известна окончательная сумма
+type Hold struct {
+	ID        string
+	AccountID int64
+	Amount    Money
+	//Fee комиссия за Amount, заблокированная вместе с суммой
+	Fee      Money
+	Category PaymentCategory
+	Status   HoldStatus
+	//PaymentID платёж, созданный при списании
+	PaymentID string
+	Expires   time.Time
+	Created   time.Time
+	Updated   time.Time
+}
+
+//Reserved сумма, которую блокировка удерживает на счёте
+func (h *Hold) Reserved() Money {
+	return h.Amount + h.Fee
+}
+
+//FeeRule правило расчёта комиссии за платёж. Пустые Category и Tier и нулевые
+//границы суммы подходят под любой платёж. Комиссия равна Fixed плюс Percent
+//базисных пунктов (1% = 100) от суммы и ограничивается MinFee и MaxFee, если они заданы.
+type FeeRule struct {
+	Category  PaymentCategory
+	Tier      AccountTier
+	MinAmount Money
+	MaxAmount Money
+	Fixed     Money
+	Percent   int64
+	MinFee    Money
+	MaxFee    Money
+}
+
+//Fee комиссия, списанная за платёж
+type Fee struct {
+	ID        string
+	PaymentID string
+	AccountID int64
+	Category  PaymentCategory
+	Amount    Money
+	//Refunded сколько комиссии возвращено при возвратах и отмене платежа
+	Refunded Money
+	Created  time.Time
+}
+
+//RewardKind вид вознаграждения
+type RewardKind string
+
+//Предопределенные виды вознаграждений
+const (
+	RewardCashback RewardKind = "CASHBACK"
+	RewardPoints   RewardKind = "POINTS"
+)
+
+//RewardRule правило начисления вознаграждения за проведённый платёж. Пустая Category
+//подходит под любой платёж. Начисляется Rate базисных пунктов (1% = 100) от суммы
+//с округлением вниз, но не больше Max, если он задан.
+type RewardRule struct {
+	Category PaymentCategory
+	Kind     RewardKind
+	Rate     int64
+	Max      int64
+}
+
+//Reward начисление или списание вознаграждения. Для кэшбэка Amount в минимальных
+//единицах валюты, для баллов - в баллах. Списания при выводе на счёт отрицательные.
+type Reward struct {
+	ID        string
+	AccountID int64
+	Kind      RewardKind
+	Amount    int64
+	//PaymentID платёж, за который начислено вознаграждение
+	PaymentID string
+	//DepositID пополнение, которым вознаграждение выведено на счёт
+	DepositID string
+	//Reversed сколько начисления отменено при отмене и возвратах платежа
+	Reversed int64
+	Created  time.Time
+}
+
+//RewardBalance остаток вознаграждений аккаунта
+type RewardBalance struct {
+	Cashback Money
+	Points   int64
+}
+
+//Budget месячный бюджет аккаунта на категорию вместе с дочерними
+type Budget struct {
+	AccountID int64
+	Category  PaymentCategory
+	Amount    Money
+	Created   time.Time
+	Updated   time.Time
+}
+
+//BudgetAlert уведомление о том, что расходы по бюджету достигли порога
+type BudgetAlert struct {
+	AccountID int64
+	Category  PaymentCategory
+	//Threshold достигнутый порог в процентах от бюджета
+	Threshold int
+	Limit     Money
+	Spent     Money
+	//Period начало месяца, за который считаются расходы
+	Period time.Time
+	At     time.Time
+}
+
+//BudgetStatus состояние бюджета в текущем месяце
+type BudgetStatus struct {
+	Category  PaymentCategory
+	Limit     Money
+	Spent     Money
+	Remaining Money
+	//Percent израсходованная часть бюджета в процентах
+	Percent int
+	Period  time.Time
+}
+
+//EventType вид доменного события
+type EventType string
+
+//Доменные события сервиса
+const (
+	EventAccountRegistered EventType = "ACCOUNT_REGISTERED"
+	EventDeposited         EventType = "DEPOSITED"
+	EventDepositReversed   EventType = "DEPOSIT_REVERSED"
+	EventPaymentCreated    EventType = "PAYMENT_CREATED"
+	EventPaymentCompleted  EventType = "PAYMENT_COMPLETED"
+	EventPaymentRejected   EventType = "PAYMENT_REJECTED"
+	EventPaymentRefunded   EventType = "PAYMENT_REFUNDED"
+	EventTransferCreated   EventType = "TRANSFER_CREATED"
+	EventTransferRejected  EventType = "TRANSFER_REJECTED"
+	EventFavoriteCreated   EventType = "FAVORITE_CREATED"
+	EventFavoriteUpdated   EventType = "FAVORITE_UPDATED"
+	EventFavoriteDeleted   EventType = "FAVORITE_DELETED"
+	EventHoldAuthorized    EventType = "HOLD_AUTHORIZED"
+	EventHoldCaptured      EventType = "HOLD_CAPTURED"
+	EventHoldReleased      EventType = "HOLD_RELEASED"
+	EventOverdraftCharged  EventType = "OVERDRAFT_CHARGED"
+)
+
+//Event доменное событие. Seq растёт на единицу с каждым событием сервиса,
+//EntityID - ID платежа, перевода, пополнения, избранного, блокировки или комиссии за овердрафт.
+//Для переводов AccountID - отправитель, CounterAccountID - получатель.
+type Event struct {
+	Seq              uint64
+	Type             EventType
+	AccountID        int64
+	CounterAccountID int64
+	EntityID         string
+	Amount           Money
+	At               time.Time
+}
+
+//AuditRecord запись журнала аудита. Entity - вид и ID сущности ("payment:<id>"),
+//Before и After - её состояние до и после изменения, пустые для созданной и удалённой.
+//Hash считается от всех полей вместе с PrevHash - хэшем предыдущей записи.
+type AuditRecord struct {
+	Seq      uint64
+	At       time.Time
+	Actor    string
+	Action   string
+	Entity   string
+	Before   string
+	After    string
+	PrevHash string
+	Hash     string
 }
 
 type Progress struct {
 	Part   int
 	Result Money
 }
+
+//Transfer перевод между двумя счетами
+type Transfer struct {
+	ID            string
+	FromAccountID int64
+	ToAccountID   int64
+	Amount        Money
+	Status        PaymentStatus
+	Created       time.Time
+	Updated       time.Time
+}
+
+//Entry проводка журнала. Каждая операция записывает две проводки с общим
+//OperationID: списание со счёта-дебета и зачисление на счёт-кредит.
+type Entry struct {
+	ID               string
+	OperationID      string
+	AccountID        int64
+	CounterAccountID int64
+	//Amount положительный при зачислении на AccountID и отрицательный при списании
+	Amount Money
+}
+
+//Deposit представляет информацию о пополнении счёта
+type Deposit struct {
+	ID        string
+	AccountID int64
+	Amount    Money
+	//Source откуда пришли деньги (касса, карта, перевод и т.д.)
+	Source  string
+	Status  PaymentStatus
+	Created time.Time
+	Updated time.Time
+}
+
+//Refund возврат части или всей суммы платежа
+type Refund struct {
+	ID        string
+	PaymentID string
+	AccountID int64
+	Amount    Money
+	Created   time.Time
+}
+
+//OverdraftCharge списание комиссии или процентов за уход в минус
+type OverdraftCharge struct {
+	ID        string
+	AccountID int64
+	//Balance баланс на момент начисления
+	Balance Money
+	Amount  Money
+	Created time.Time
+}
+
+//SpendingLimits лимиты расходов аккаунта. Нулевой лимит означает его отсутствие.
+type SpendingLimits struct {
+	Daily   Money
+	Monthly Money
+	//Categories дневные лимиты по категориям платежей
+	Categories map[PaymentCategory]Money
+}
+
+//SchedulePeriod периодичность регулярного платежа
+type SchedulePeriod string
+
+//Предопределенные периоды регулярных платежей
+const (
+	ScheduleDaily   SchedulePeriod = "DAILY"
+	ScheduleWeekly  SchedulePeriod = "WEEKLY"
+	ScheduleMonthly SchedulePeriod = "MONTHLY"
+)
+
+//ScheduleStatus статус регулярного платежа
+type ScheduleStatus string
+
+//Предопределенные статусы регулярных платежей
+const (
+	ScheduleStatusActive    ScheduleStatus = "ACTIVE"
+	ScheduleStatusPaused    ScheduleStatus = "PAUSED"
+	ScheduleStatusCancelled ScheduleStatus = "CANCELLED"
+)
+
+//Schedule регулярный платёж по шаблону из избранного
+type Schedule struct {
+	ID         string
+	FavoriteID string
+	AccountID  int64
+	Period     SchedulePeriod
+	//Day день недели (0 - воскресенье) для WEEKLY или день месяца (1-31) для MONTHLY
+	Day     int
+	Status  ScheduleStatus
+	NextRun time.Time
+	Created time.Time
+	Updated time.Time
+}
+
+//ScheduleRun результат запуска регулярного платежа
+type ScheduleRun struct {
+	ScheduleID string
+	At         time.Time
+	//PaymentID созданный платёж, пустой при ошибке
+	PaymentID string
+	//Error текст ошибки, пустой при успехе
+	Error string
+}
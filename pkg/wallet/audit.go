@@ -0,0 +1,341 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrAuditTampered = errors.New("audit log was altered")
+var ErrAuditDisabled = errors.New("audit log is not enabled")
+var ErrAuditWrite = errors.New("audit log write failed")
+
+//DefaultAuditActor исполнитель, от имени которого пишутся записи вызовов без AsActor
+const DefaultAuditActor = "system"
+
+//CallOption настройка одного вызова метода, изменяющего данные
+type CallOption func(call *callOptions)
+
+//callOptions настройки вызова, собранные из CallOption
+type callOptions struct {
+	actor string
+}
+
+//AsActor записывает изменения, сделанные вызовом, в журнал аудита от имени actor
+func AsActor(actor string) CallOption {
+	return func(call *callOptions) {
+		call.actor = actor
+	}
+}
+
+//auditGenesisHash PrevHash первой записи журнала
+var auditGenesisHash = strings.Repeat("0", sha256.Size*2)
+
+//audit журнал аудита. head - хэш последней записи, states - последнее
+//записанное состояние каждой сущности, из него берётся Before следующей записи.
+//actor и err относятся к текущему вызову и сбрасываются в unlock.
+type audit struct {
+	path   string
+	file   *os.File
+	actor  string
+	err    error
+	seq    uint64
+	head   string
+	states map[string]string
+}
+
+//EnableAudit включает журнал аудита: каждое изменение данных сервиса и каждый импорт
+//дописываются в файл path. Существующий журнал сначала проверяется, новые записи
+//продолжают его цепочку хэшей. Если запись не удалась, изменивший данные метод
+//возвращает ErrAuditWrite, а изменение остаётся в сервисе без записи в журнале.
+func (s *Service) EnableAudit(path string) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	records, err := VerifyAuditLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	s.closeAudit()
+
+	s.audit.path = path
+	s.audit.file = file
+	s.audit.seq = 0
+	s.audit.head = auditGenesisHash
+	s.audit.states = make(map[string]string)
+	for _, record := range records {
+		s.audit.seq = record.Seq
+		s.audit.head = record.Hash
+		s.audit.setState(record.Entity, record.After)
+	}
+	return nil
+}
+
+//DisableAudit выключает журнал аудита и закрывает его файл
+func (s *Service) DisableAudit() error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	return s.closeAudit()
+}
+
+func (s *Service) closeAudit() error {
+	if s.audit.file == nil {
+		return nil
+	}
+	err := s.audit.file.Close()
+	if err != nil {
+		log.Print(err)
+	}
+	s.audit.file = nil
+	return err
+}
+
+//VerifyAudit проверяет файл журнала и что он заканчивается последней записью,
+//сделанной сервисом, то есть из конца журнала ничего не удалено
+func (s *Service) VerifyAudit() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.audit.file == nil {
+		return &Error{Err: ErrAuditDisabled}
+	}
+
+	records, err := VerifyAuditLog(s.audit.path)
+	if err != nil {
+		return err
+	}
+	head := auditGenesisHash
+	if len(records) > 0 {
+		head = records[len(records)-1].Hash
+	}
+	if uint64(len(records)) != s.audit.seq || head != s.audit.head {
+		return &Error{
+			Err:   ErrAuditTampered,
+			ID:    s.audit.path,
+			Cause: fmt.Errorf("log has %d records, service wrote %d", len(records), s.audit.seq),
+		}
+	}
+	return nil
+}
+
+//VerifyAuditLog читает журнал аудита и проверяет цепочку хэшей.
+//Изменённая, вставленная или удалённая запись даёт ErrAuditTampered с номером записи.
+//Удаление записей с конца журнала так не обнаружить, для этого есть VerifyAudit.
+func VerifyAuditLog(path string) ([]types.AuditRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []types.AuditRecord{}
+	prev := auditGenesisHash
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		seq := uint64(len(records) + 1)
+		record, ok := parseAuditRecord(line)
+		if !ok || record.Seq != seq || record.PrevHash != prev || auditHash(record) != record.Hash {
+			return records, &Error{Err: ErrAuditTampered, ID: path + ":" + strconv.FormatUint(seq, 10)}
+		}
+		records = append(records, record)
+		prev = record.Hash
+	}
+	return records, nil
+}
+
+//record дописывает запись в журнал, вызывающий должен держать s.mu на запись.
+//after - новое состояние сущности, nil для удалённой.
+func (s *Service) record(action string, entity string, after interface{}) {
+	if s.audit.file == nil || s.audit.err != nil {
+		return
+	}
+
+	actor := s.audit.actor
+	if actor == "" {
+		actor = DefaultAuditActor
+	}
+	entity = dumpReplacer.Replace(entity)
+	record := types.AuditRecord{
+		Seq:      s.audit.seq + 1,
+		At:       s.now(),
+		Actor:    actor,
+		Action:   action,
+		Entity:   entity,
+		Before:   s.audit.states[entity],
+		After:    auditState(after),
+		PrevHash: s.audit.head,
+	}
+	record.Hash = auditHash(record)
+
+	_, err := s.audit.file.WriteString(auditLine(record) + ";" + record.Hash + "\n")
+	if err != nil {
+		log.Print(err)
+		s.audit.err = &Error{Err: ErrAuditWrite, ID: entity, Cause: err}
+		return
+	}
+	s.audit.seq = record.Seq
+	s.audit.head = record.Hash
+	s.audit.setState(record.Entity, record.After)
+}
+
+//recordAccount пишет в журнал состояние аккаунта после проводки или изменения блокировок,
+//чтобы Before следующей записи об аккаунте совпадал с его настоящим состоянием.
+//Системных счетов журнала в хранилище нет, для них ничего не пишется.
+func (s *Service) recordAccount(action string, accountID int64) {
+	if s.audit.file == nil {
+		return
+	}
+	account, ok := s.repositories().Accounts.ByID(accountID)
+	if !ok {
+		return
+	}
+	s.record(action, auditAccount(accountID), *account)
+}
+
+//recordEvent пишет в журнал состояние сущности, изменённой событием
+func (s *Service) recordEvent(event types.Event) {
+	if s.audit.file == nil {
+		return
+	}
+
+	var entity string
+	var after interface{}
+	switch event.Type {
+	case types.EventAccountRegistered:
+		entity = auditAccount(event.AccountID)
+		if account, err := s.findAccountByID(event.AccountID); err == nil {
+			after = *account
+		}
+	case types.EventDeposited, types.EventDepositReversed:
+		entity = "deposit:" + event.EntityID
+		if deposit, err := s.findDepositByID(event.EntityID); err == nil {
+			after = *deposit
+		}
+	case types.EventPaymentCreated, types.EventPaymentCompleted, types.EventPaymentRejected, types.EventPaymentRefunded:
+		entity = "payment:" + event.EntityID
+		if payment, err := s.findPaymentByID(event.EntityID); err == nil {
+			after = *payment
+		}
+	case types.EventTransferCreated, types.EventTransferRejected:
+		entity = "transfer:" + event.EntityID
+		if transfer, err := s.findTransferByID(event.EntityID); err == nil {
+			after = *transfer
+		}
+	case types.EventFavoriteCreated, types.EventFavoriteUpdated, types.EventFavoriteDeleted:
+		entity = "favorite:" + event.EntityID
+		if favorite, err := s.getFavoriteByID(event.EntityID); err == nil {
+			after = *favorite
+		}
+	case types.EventHoldAuthorized, types.EventHoldCaptured, types.EventHoldReleased:
+		entity = "hold:" + event.EntityID
+		if hold, err := s.findHoldByID(event.EntityID); err == nil {
+			after = *hold
+		}
+	case types.EventOverdraftCharged:
+		//списание уже записано в ChargeOverdraftFees вместе с types.OverdraftCharge
+		return
+	}
+	s.record(string(event.Type), entity, after)
+}
+
+func (a *audit) setState(entity string, state string) {
+	if state == "" {
+		delete(a.states, entity)
+		return
+	}
+	a.states[entity] = state
+}
+
+func auditAccount(accountID int64) string {
+	return "account:" + strconv.FormatInt(accountID, 10)
+}
+
+func auditBudget(accountID int64, category types.PaymentCategory) string {
+	return "budget:" + strconv.FormatInt(accountID, 10) + ":" + string(category)
+}
+
+//importSummary сколько записей в сервисе после импорта
+func (s *Service) importSummary() string {
+	return "accounts=" + strconv.Itoa(len(s.repositories().Accounts.All())) +
+		" payments=" + strconv.Itoa(len(s.repositories().Payments.All())) +
+		" favorites=" + strconv.Itoa(len(s.repositories().Favorites.All())) +
+		" transfers=" + strconv.Itoa(len(s.transfers)) +
+		" deposits=" + strconv.Itoa(len(s.deposits)) +
+		" refunds=" + strconv.Itoa(len(s.refunds))
+}
+
+//auditState записывает состояние сущности в JSON: порядок полей и ключей
+//не меняется между запусками, а время пишется без показаний монотонных часов
+func auditState(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if str, ok := value.(string); ok {
+		return dumpReplacer.Replace(str)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Print(err)
+		return dumpReplacer.Replace(fmt.Sprintf("%+v", value))
+	}
+	return dumpReplacer.Replace(string(data))
+}
+
+//auditLine запись журнала без хэша, от неё и считается хэш
+func auditLine(record types.AuditRecord) string {
+	return strconv.FormatUint(record.Seq, 10) + ";" +
+		formatTime(record.At) + ";" +
+		record.Actor + ";" +
+		record.Action + ";" +
+		record.Entity + ";" +
+		record.Before + ";" +
+		record.After + ";" +
+		record.PrevHash
+}
+
+func auditHash(record types.AuditRecord) string {
+	sum := sha256.Sum256([]byte(auditLine(record)))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseAuditRecord(line string) (types.AuditRecord, bool) {
+	fields := strings.Split(line, ";")
+	if len(fields) != 9 {
+		return types.AuditRecord{}, false
+	}
+	seq, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return types.AuditRecord{}, false
+	}
+	at, err := parseTime(fields[1])
+	if err != nil {
+		return types.AuditRecord{}, false
+	}
+	return types.AuditRecord{
+		Seq:      seq,
+		At:       at,
+		Actor:    fields[2],
+		Action:   fields[3],
+		Entity:   fields[4],
+		Before:   fields[5],
+		After:    fields[6],
+		PrevHash: fields[7],
+		Hash:     fields[8],
+	}, true
+}
@@ -0,0 +1,282 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_EnableAudit(t *testing.T) {
+	s := newTestService()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	err := s.EnableAudit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 10_00, "auto", AsActor("operator"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetOverdraftLimit(account.ID, 50_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Import(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		string(types.EventAccountRegistered),
+		"BALANCE_CHANGED",
+		string(types.EventDeposited),
+		"BALANCE_CHANGED",
+		string(types.EventPaymentCreated),
+		"BALANCE_CHANGED",
+		string(types.EventPaymentRejected),
+		"SET_OVERDRAFT_LIMIT",
+		"IMPORT",
+	}
+	if len(records) != len(want) {
+		t.Fatalf("VerifyAuditLog(): records = %v, want actions %v", records, want)
+	}
+	for i, record := range records {
+		if record.Action != want[i] {
+			t.Errorf("VerifyAuditLog(): action = %v, want %v", record.Action, want[i])
+		}
+	}
+
+	created, rejected := records[4], records[6]
+	if created.Actor != "operator" || rejected.Actor != DefaultAuditActor {
+		t.Errorf("VerifyAuditLog(): wrong actors %v, %v", created.Actor, rejected.Actor)
+	}
+	if created.Entity != "payment:"+payment.ID || created.Before != "" || rejected.Before != created.After {
+		t.Errorf("VerifyAuditLog(): wrong payment records %v, %v", created, rejected)
+	}
+	if !strings.Contains(rejected.After, string(types.PaymentStatusFail)) || !rejected.At.Equal(s.clock.Now()) {
+		t.Errorf("VerifyAuditLog(): wrong rejected payment record = %v", rejected)
+	}
+	//Before берётся из последней записи об аккаунте, сделанной при возврате средств
+	if records[7].Before != records[5].After || !strings.Contains(records[7].Before, `"Balance":10000`) ||
+		!strings.Contains(records[7].After, `"OverdraftLimit":5000`) {
+		t.Errorf("VerifyAuditLog(): wrong account record = %v", records[7])
+	}
+
+	err = s.VerifyAudit()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyAuditLog_tampered(t *testing.T) {
+	s := newTestService()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	err := s.EnableAudit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.Pay(account.ID, 10_00, "auto"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	tests := []struct {
+		name  string
+		lines []string
+	}{
+		{"edited", append(append(lines[:2:2], strings.Replace(lines[2], `"Amount":1000`, `"Amount":100`, 1)), lines[3:]...)},
+		{"removed", append(lines[:1:1], lines[2:]...)},
+		{"swapped", append([]string{lines[1], lines[0]}, lines[2:]...)},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(strings.Join(tt.lines, "")), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = VerifyAuditLog(path)
+		if !errors.Is(err, ErrAuditTampered) || CodeOf(err) != CodeAuditTampered {
+			t.Errorf("VerifyAuditLog(): %v log must return ErrAuditTampered, returned = %v", tt.name, err)
+		}
+	}
+
+	err = os.WriteFile(path, []byte(strings.Join(lines[:len(lines)-2], "")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.VerifyAudit()
+	if !errors.Is(err, ErrAuditTampered) || CodeOf(err) != CodeAuditTampered {
+		t.Errorf("VerifyAudit(): truncated log must return ErrAuditTampered, returned = %v", err)
+	}
+}
+
+func TestService_EnableAudit_continuesLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	s := newTestService()
+	err := s.EnableAudit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.DisableAudit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetAccountTier(account.ID, "premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.EnableAudit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetOverdraftLimit(account.ID, 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || records[3].Seq != 4 || records[3].Before != records[1].After {
+		t.Errorf("EnableAudit(): log must continue, records = %v", records)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(strings.Replace(string(data), "+992000000001", "+992000000002", 1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newTestService().EnableAudit(path)
+	if !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("EnableAudit(): tampered log must return ErrAuditTampered, returned = %v", err)
+	}
+}
+
+func TestService_EnableAudit_stableState(t *testing.T) {
+	s := &Service{}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := s.EnableAudit(path); err != nil {
+		t.Fatal(err)
+	}
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || strings.Contains(records[0].After, "m=") {
+		t.Fatalf("VerifyAuditLog(): state must not contain monotonic clock, records = %v", records)
+	}
+	got := types.Account{}
+	if err := json.Unmarshal([]byte(records[0].After), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != account.ID || got.Phone != account.Phone || !got.Created.Equal(account.Created) {
+		t.Errorf("VerifyAuditLog(): state = %v, want %v", got, *account)
+	}
+}
+
+func TestService_AsActor_perCall(t *testing.T) {
+	s := newTestService()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := s.EnableAudit(path); err != nil {
+		t.Fatal(err)
+	}
+
+	//телефоны аккаунтов исполнителя начинаются с его префикса
+	actors := map[string]string{"alice": "+99201", "bob": "+99202"}
+	wg := sync.WaitGroup{}
+	for actor, prefix := range actors {
+		wg.Add(1)
+		go func(actor string, prefix string) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if _, err := s.RegisterAccount(types.Phone(prefix+strconv.Itoa(1000+i)), AsActor(actor)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(actor, prefix)
+	}
+	wg.Wait()
+
+	records, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 40 {
+		t.Fatalf("VerifyAuditLog(): records = %v, want 40", len(records))
+	}
+	for _, record := range records {
+		if !strings.Contains(record.After, `"Phone":"`+actors[record.Actor]) {
+			t.Errorf("VerifyAuditLog(): record of another call = %v", record)
+		}
+	}
+}
+
+func TestService_EnableAudit_writeError(t *testing.T) {
+	s := newTestService()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := s.EnableAudit(path); err != nil {
+		t.Fatal(err)
+	}
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//закрытый файл: запись в журнал не удастся
+	if err := s.audit.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, 10_00, "auto")
+	if !errors.Is(err, ErrAuditWrite) || CodeOf(err) == CodeUnknown {
+		t.Errorf("Pay(): must return ErrAuditWrite, returned = %v", err)
+	}
+	err = s.SetOverdraftLimit(account.ID, 10_00)
+	if !errors.Is(err, ErrAuditWrite) {
+		t.Errorf("SetOverdraftLimit(): must return ErrAuditWrite, returned = %v", err)
+	}
+}
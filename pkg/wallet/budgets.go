@@ -0,0 +1,340 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrBudgetNotFound = errors.New("budget not found")
+
+//BudgetThresholds пороги расходов в процентах от бюджета, при которых отправляются уведомления
+var BudgetThresholds = []int{80, 100}
+
+//BudgetNotifier получает уведомления о расходах по бюджетам.
+//Вызывается синхронно после снятия блокировки сервиса и может вызывать его методы.
+type BudgetNotifier interface {
+	NotifyBudget(alert types.BudgetAlert)
+}
+
+//BudgetNotifierFunc позволяет использовать функцию как BudgetNotifier
+type BudgetNotifierFunc func(alert types.BudgetAlert)
+
+//NotifyBudget вызывает f(alert)
+func (f BudgetNotifierFunc) NotifyBudget(alert types.BudgetAlert) {
+	f(alert)
+}
+
+//budget бюджет и состояние уведомлений по нему
+type budget struct {
+	budget *types.Budget
+	//period месяц, к которому относится notified
+	period time.Time
+	//notified наибольший порог, о котором уже уведомили в period
+	notified int
+}
+
+//budgets бюджеты аккаунтов
+type budgets struct {
+	notifier  BudgetNotifier
+	byAccount map[int64][]*budget
+	//pending уведомления, накопленные под блокировкой, см. unlock
+	pending []types.BudgetAlert
+}
+
+//SetBudgetNotifier задаёт получателя уведомлений о бюджетах, nil отключает уведомления
+func (s *Service) SetBudgetNotifier(notifier BudgetNotifier) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	s.budgets.notifier = notifier
+}
+
+//SetBudget задаёт месячный бюджет аккаунта на категорию. Расходы дочерних
+//категорий входят в бюджет родительской.
+func (s *Service) SetBudget(accountID int64, category types.PaymentCategory, amount types.Money, opts ...CallOption) (_ *types.Budget, err error) {
+	if amount <= 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, AccountID: accountID, Amount: amount}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	_, err = s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	category, err = s.resolveCategory(category)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	if item := s.findBudget(accountID, category); item != nil {
+		item.budget.Amount = amount
+		item.budget.Updated = now
+		s.record("SET_BUDGET", auditBudget(accountID, category), *item.budget)
+		return copyBudget(item.budget, nil)
+	}
+
+	item := &budget{
+		budget: &types.Budget{
+			AccountID: accountID,
+			Category:  category,
+			Amount:    amount,
+			Created:   now,
+			Updated:   now,
+		},
+	}
+	s.insertBudget(item)
+	s.record("SET_BUDGET", auditBudget(accountID, category), *item.budget)
+	return copyBudget(item.budget, nil)
+}
+
+//RemoveBudget удаляет бюджет аккаунта на категорию
+func (s *Service) RemoveBudget(accountID int64, category types.PaymentCategory, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	category = s.canonicalCategory(category)
+	items := s.budgets.byAccount[accountID]
+	for i, item := range items {
+		if item.budget.Category == category {
+			s.budgets.byAccount[accountID] = append(items[:i:i], items[i+1:]...)
+			s.record("REMOVE_BUDGET", auditBudget(accountID, category), nil)
+			return nil
+		}
+	}
+	return &Error{Err: ErrBudgetNotFound, AccountID: accountID, ID: string(category)}
+}
+
+//BudgetStatus возвращает состояние всех бюджетов аккаунта в текущем месяце, по категориям
+func (s *Service) BudgetStatus(accountID int64) ([]types.BudgetStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	period := monthStart(s.now())
+	statuses := []types.BudgetStatus{}
+	for _, item := range s.budgets.byAccount[accountID] {
+		spent := s.budgetSpent(item.budget, period)
+		remaining := item.budget.Amount - spent
+		if remaining < 0 {
+			remaining = 0
+		}
+		statuses = append(statuses, types.BudgetStatus{
+			Category:  item.budget.Category,
+			Limit:     item.budget.Amount,
+			Spent:     spent,
+			Remaining: remaining,
+			Percent:   budgetPercent(spent, item.budget.Amount),
+			Period:    period,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Category < statuses[j].Category
+	})
+	return statuses, nil
+}
+
+//checkBudgets уведомляет о бюджетах аккаунта, расходы по которым впервые
+//за месяц достигли очередного порога
+func (s *Service) checkBudgets(accountID int64) {
+	if s.budgets.notifier == nil {
+		return
+	}
+
+	now := s.now()
+	period := monthStart(now)
+	for _, item := range s.budgets.byAccount[accountID] {
+		if !item.period.Equal(period) {
+			item.period = period
+			item.notified = 0
+		}
+
+		spent := s.budgetSpent(item.budget, period)
+		percent := budgetPercent(spent, item.budget.Amount)
+		for _, threshold := range BudgetThresholds {
+			if percent < threshold || threshold <= item.notified {
+				continue
+			}
+			item.notified = threshold
+			s.budgets.pending = append(s.budgets.pending, types.BudgetAlert{
+				AccountID: accountID,
+				Category:  item.budget.Category,
+				Threshold: threshold,
+				Limit:     item.budget.Amount,
+				Spent:     spent,
+				Period:    period,
+				At:        now,
+			})
+		}
+	}
+}
+
+//takeBudgetAlerts забирает накопленные уведомления вместе с получателем,
+//вызывающий должен держать s.mu на запись
+func (s *Service) takeBudgetAlerts() (BudgetNotifier, []types.BudgetAlert) {
+	alerts := s.budgets.pending
+	s.budgets.pending = nil
+	return s.budgets.notifier, alerts
+}
+
+//notifyBudgets доставляет уведомления, вызывается без блокировки сервиса
+func notifyBudgets(notifier BudgetNotifier, alerts []types.BudgetAlert) {
+	if notifier == nil {
+		return
+	}
+	for _, alert := range alerts {
+		notifier.NotifyBudget(alert)
+	}
+}
+
+//budgetSpent считает расходы по бюджету с начала месяца period с учётом возвратов
+func (s *Service) budgetSpent(item *types.Budget, period time.Time) types.Money {
+	spent := types.Money(0)
+	for _, payment := range s.accountPaymentsSince(item.AccountID, period) {
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		if s.isCategoryWithin(s.canonicalCategory(payment.Category), item.Category) {
+			spent += payment.Amount - payment.Refunded
+		}
+	}
+	return spent
+}
+
+func (s *Service) findBudget(accountID int64, category types.PaymentCategory) *budget {
+	for _, item := range s.budgets.byAccount[accountID] {
+		if item.budget.Category == category {
+			return item
+		}
+	}
+	return nil
+}
+
+//insertBudget добавляет бюджет в хранилище
+func (s *Service) insertBudget(item *budget) {
+	if s.budgets.byAccount == nil {
+		s.budgets.byAccount = make(map[int64][]*budget)
+	}
+	s.budgets.byAccount[item.budget.AccountID] = append(s.budgets.byAccount[item.budget.AccountID], item)
+}
+
+//budgetPercent возвращает spent в процентах от limit, с округлением вниз
+func budgetPercent(spent types.Money, limit types.Money) int {
+	return int(int64(spent) * 100 / int64(limit))
+}
+
+//monthStart возвращает начало месяца, в котором находится t
+func monthStart(t time.Time) time.Time {
+	year, month, _ := t.Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+}
+
+//exportBudgets записывает бюджеты в budgets.dump
+func (s *Service) exportBudgets(dir string) error {
+	if len(s.budgets.byAccount) == 0 {
+		return nil
+	}
+
+	accountIDs := make([]int64, 0, len(s.budgets.byAccount))
+	for accountID := range s.budgets.byAccount {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Slice(accountIDs, func(i, j int) bool {
+		return accountIDs[i] < accountIDs[j]
+	})
+
+	budData := make([]byte, 0)
+
+	for _, accountID := range accountIDs {
+		for _, item := range s.budgets.byAccount[accountID] {
+			str := strconv.FormatInt(item.budget.AccountID, 10) + (";") +
+				string(item.budget.Category) + (";") +
+				strconv.FormatInt(int64(item.budget.Amount), 10) + (";") +
+				formatTime(item.budget.Created) + (";") +
+				formatTime(item.budget.Updated) + (";") +
+				formatTime(item.period) + (";") +
+				strconv.Itoa(item.notified) + ("\n")
+
+			budData = append(budData, []byte(str)...)
+		}
+	}
+	err := os.WriteFile(dir+"/budgets.dump", budData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importBudgets читает бюджеты из budgets.dump вместе с состоянием уведомлений
+func (s *Service) importBudgets(dir string) error {
+	path := dir + "/budgets.dump"
+	budFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, budOperation := range strings.Split(string(budFile), "\n") {
+		if len(budOperation) == 0 {
+			break
+		}
+		budStr, err := dumpFields(path, i+1, budOperation, 7)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		accountID, err := strconv.ParseInt(budStr[0], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		category := types.PaymentCategory(budStr[1])
+		amount, err := strconv.ParseInt(budStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		created, updated, err := parseTimes(budStr, 3)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		period, err := parseTime(budStr[5])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		notified, err := strconv.Atoi(budStr[6])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		item := s.findBudget(accountID, category)
+		if item == nil {
+			item = &budget{budget: &types.Budget{AccountID: accountID, Category: category}}
+			s.insertBudget(item)
+		}
+		item.budget.Amount = types.Money(amount)
+		item.budget.Created = created
+		item.budget.Updated = updated
+		item.period = period
+		item.notified = notified
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+//recordingNotifier запоминает все уведомления о бюджетах
+type recordingNotifier struct {
+	alerts []types.BudgetAlert
+}
+
+func (n *recordingNotifier) NotifyBudget(alert types.BudgetAlert) {
+	n.alerts = append(n.alerts, alert)
+}
+
+func TestService_budgets_alerts(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := &recordingNotifier{}
+	s.SetBudgetNotifier(notifier)
+	_, err = s.SetBudget(account.ID, "food", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, amount := range []types.Money{50_00, 29_00, 10_00, 5_00} {
+		if _, err := s.Pay(account.ID, amount, "food"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(notifier.alerts) != 1 {
+		t.Fatalf("Pay(): one alert expected, alerts = %v", notifier.alerts)
+	}
+	alert := notifier.alerts[0]
+	if alert.Threshold != 80 || alert.Spent != 89_00 || alert.Limit != 100_00 || alert.Category != "food" {
+		t.Errorf("Pay(): wrong alert = %v", alert)
+	}
+	if !alert.Period.Equal(monthStart(s.clock.Now())) || !alert.At.Equal(s.clock.Now()) {
+		t.Errorf("Pay(): wrong alert times = %v", alert)
+	}
+
+	if _, err := s.Pay(account.ID, 500_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pay(account.ID, 20_00, "food"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.alerts) != 2 || notifier.alerts[1].Threshold != 100 || notifier.alerts[1].Spent != 114_00 {
+		t.Errorf("Pay(): 100%% alert expected, alerts = %v", notifier.alerts)
+	}
+	if _, err := s.Pay(account.ID, 20_00, "food"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.alerts) != 2 {
+		t.Errorf("Pay(): alerts must not repeat in the same month, alerts = %v", notifier.alerts)
+	}
+
+	s.clock.Add(31 * 24 * time.Hour)
+	if _, err := s.Pay(account.ID, 120_00, "food"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.alerts) != 4 || notifier.alerts[2].Threshold != 80 || notifier.alerts[3].Threshold != 100 {
+		t.Errorf("Pay(): new month must alert again, alerts = %v", notifier.alerts)
+	}
+}
+
+func TestService_budgets_includeChildCategories(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestCategories(t, s)
+	alerts := 0
+	s.SetBudgetNotifier(BudgetNotifierFunc(func(alert types.BudgetAlert) {
+		alerts++
+	}))
+
+	_, err = s.SetBudget(account.ID, "Food", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.SetBudget(account.ID, "bank", 100_00)
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("SetBudget(): must return ErrUnknownCategory, returned = %v", err)
+	}
+
+	if _, err := s.Pay(account.ID, 40_00, "restaurant"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pay(account.ID, 45_00, "coffee"); err != nil {
+		t.Fatal(err)
+	}
+	if alerts != 1 {
+		t.Errorf("Pay(): child categories must count towards budget, alerts = %v", alerts)
+	}
+}
+
+func TestService_BudgetStatus(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, budget := range []struct {
+		category types.PaymentCategory
+		amount   types.Money
+	}{
+		{"food", 100_00},
+		{"auto", 200_00},
+	} {
+		if _, err := s.SetBudget(account.ID, budget.category, budget.amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Pay(account.ID, 150_00, "food"); err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 50_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pay(account.ID, 20_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := s.BudgetStatus(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	period := monthStart(s.clock.Now())
+	want := []types.BudgetStatus{
+		{Category: "auto", Limit: 200_00, Spent: 20_00, Remaining: 180_00, Percent: 10, Period: period},
+		{Category: "food", Limit: 100_00, Spent: 150_00, Remaining: 0, Percent: 150, Period: period},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("BudgetStatus(): statuses = %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("BudgetStatus(): status = %v, want %v", statuses[i], want[i])
+		}
+	}
+
+	err = s.RemoveBudget(account.ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RemoveBudget(account.ID, "auto")
+	if !errors.Is(err, ErrBudgetNotFound) {
+		t.Errorf("RemoveBudget(): must return ErrBudgetNotFound, returned = %v", err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err = imported.BudgetStatus(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0] != want[1] {
+		t.Errorf("Import(): statuses = %v, want %v", statuses, want[1:])
+	}
+}
+
+func TestService_BudgetNotifier_afterUnlock(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var balance types.Money
+	s.SetBudgetNotifier(BudgetNotifierFunc(func(alert types.BudgetAlert) {
+		//вызов сервиса из получателя не должен блокироваться
+		got, err := s.FindAccountByID(alert.AccountID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		balance = got.Balance
+	}))
+	_, err = s.SetBudget(account.ID, "food", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Pay(account.ID, 90_00, "food"); err != nil {
+		t.Fatal(err)
+	}
+	if balance != 910_00 {
+		t.Errorf("NotifyBudget(): must see the payment, balance = %v", balance)
+	}
+}
@@ -0,0 +1,314 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrUnknownCategory = errors.New("unknown payment category")
+var ErrCategoryExists = errors.New("category code or alias already registered")
+var ErrInvalidCategory = errors.New("invalid category")
+
+//categories справочник категорий платежей
+type categories struct {
+	byCode  map[types.PaymentCategory]*types.Category
+	byAlias map[string]types.PaymentCategory
+}
+
+//normalizeCategory приводит код или псевдоним к виду, в котором он хранится в справочнике
+func normalizeCategory(name string) string {
+	return strings.ToLower(strings.TrimSpace(dumpReplacer.Replace(name)))
+}
+
+//RegisterCategory добавляет категорию в справочник. Код и псевдонимы сравниваются
+//без учёта регистра и не должны совпадать с уже зарегистрированными.
+//Родительская категория должна быть зарегистрирована раньше дочерней.
+func (s *Service) RegisterCategory(category types.Category, opts ...CallOption) (_ *types.Category, err error) {
+	code := types.PaymentCategory(normalizeCategory(string(category.Code)))
+	if code == "" {
+		return nil, &Error{Err: ErrInvalidCategory, ID: string(category.Code)}
+	}
+	parent := types.PaymentCategory(normalizeCategory(string(category.Parent)))
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	if _, ok := s.categories.byCode[code]; ok {
+		return nil, &Error{Err: ErrCategoryExists, ID: string(code)}
+	}
+	if _, ok := s.categories.byAlias[string(code)]; ok {
+		return nil, &Error{Err: ErrCategoryExists, ID: string(code)}
+	}
+	if parent != "" {
+		if _, ok := s.categories.byCode[parent]; !ok {
+			return nil, &Error{Err: ErrUnknownCategory, ID: string(parent)}
+		}
+	}
+
+	registered := &types.Category{
+		Code:   code,
+		Name:   dumpReplacer.Replace(category.Name),
+		Parent: parent,
+	}
+	for _, alias := range category.Aliases {
+		alias = strings.ReplaceAll(normalizeCategory(alias), ",", " ")
+		if alias == "" || alias == string(code) {
+			continue
+		}
+		if _, ok := s.categories.byCode[types.PaymentCategory(alias)]; ok {
+			return nil, &Error{Err: ErrCategoryExists, ID: alias}
+		}
+		if _, ok := s.categories.byAlias[alias]; ok {
+			return nil, &Error{Err: ErrCategoryExists, ID: alias}
+		}
+		registered.Aliases = append(registered.Aliases, alias)
+	}
+
+	s.insertCategory(registered)
+	s.record("REGISTER_CATEGORY", "category:"+string(registered.Code), *registered)
+	return copyCategory(registered, nil)
+}
+
+//Categories возвращает справочник категорий, отсортированный по коду
+func (s *Service) Categories() []types.Category {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	categories := make([]types.Category, 0, len(s.categories.byCode))
+	for _, category := range s.categories.byCode {
+		categories = append(categories, *category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Code < categories[j].Code
+	})
+	return categories
+}
+
+//ResolveCategory возвращает каноничный код категории по коду или псевдониму.
+//Пока справочник пуст, категория возвращается без изменений.
+func (s *Service) ResolveCategory(category types.PaymentCategory) (types.PaymentCategory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.resolveCategory(category)
+}
+
+func (s *Service) resolveCategory(category types.PaymentCategory) (types.PaymentCategory, error) {
+	if len(s.categories.byCode) == 0 {
+		return types.PaymentCategory(dumpReplacer.Replace(string(category))), nil
+	}
+
+	name := normalizeCategory(string(category))
+	if _, ok := s.categories.byCode[types.PaymentCategory(name)]; ok {
+		return types.PaymentCategory(name), nil
+	}
+	if code, ok := s.categories.byAlias[name]; ok {
+		return code, nil
+	}
+	return "", &Error{Err: ErrUnknownCategory, ID: string(category)}
+}
+
+//CategoryFilter возвращает фильтр для FilterPaymentsByFn, отбирающий платежи
+//категории и всех её дочерних категорий, включая записанные под псевдонимами.
+//Справочник читается при создании фильтра.
+func (s *Service) CategoryFilter(category types.PaymentCategory) (func(payment types.Payment) bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	code, err := s.resolveCategory(category)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[types.PaymentCategory]bool)
+	for name := range s.categories.byCode {
+		if s.isCategoryWithin(name, code) {
+			matches[name] = true
+		}
+	}
+	for name := range s.categoryNames() {
+		if s.isCategoryWithin(s.canonicalCategory(name), code) {
+			matches[name] = true
+		}
+	}
+	matches[code] = true
+
+	return func(payment types.Payment) bool {
+		return matches[payment.Category]
+	}, nil
+}
+
+//CategoryTotals возвращает суммы проведённых платежей всех аккаунтов по категориям
+//с учётом возвратов. Сумма дочерней категории добавляется и ко всем родительским.
+func (s *Service) CategoryTotals() map[types.PaymentCategory]types.Money {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.categoryTotals(s.repositories().Payments.All())
+}
+
+//AccountCategoryTotals как CategoryTotals, но только по платежам аккаунта
+func (s *Service) AccountCategoryTotals(accountID int64) (map[types.PaymentCategory]types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	return s.categoryTotals(s.repositories().Payments.ByAccount(accountID)), nil
+}
+
+func (s *Service) categoryTotals(payments []*types.Payment) map[types.PaymentCategory]types.Money {
+	totals := make(map[types.PaymentCategory]types.Money)
+	for _, payment := range payments {
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		amount := payment.Amount - payment.Refunded
+		for _, code := range s.categoryAncestors(s.canonicalCategory(payment.Category)) {
+			totals[code] += amount
+		}
+	}
+	return totals
+}
+
+//canonicalCategory возвращает код категории из справочника или саму категорию,
+//если она в справочнике не найдена (старые данные)
+func (s *Service) canonicalCategory(category types.PaymentCategory) types.PaymentCategory {
+	code, err := s.resolveCategory(category)
+	if err != nil {
+		return category
+	}
+	return code
+}
+
+//categoryAncestors возвращает категорию и всех её родителей, начиная с неё самой
+func (s *Service) categoryAncestors(code types.PaymentCategory) []types.PaymentCategory {
+	ancestors := []types.PaymentCategory{code}
+	for {
+		category, ok := s.categories.byCode[code]
+		if !ok || category.Parent == "" {
+			return ancestors
+		}
+		code = category.Parent
+		ancestors = append(ancestors, code)
+	}
+}
+
+//categoryMatches проверяет, что правило категории rule подходит платежу категории category:
+//правило без категории подходит любому платежу, правило родительской - платежам дочерних
+func (s *Service) categoryMatches(rule types.PaymentCategory, category types.PaymentCategory) bool {
+	return rule == "" || s.isCategoryWithin(s.canonicalCategory(category), s.canonicalCategory(rule))
+}
+
+//isCategoryWithin проверяет, что code совпадает с parent или вложена в неё
+func (s *Service) isCategoryWithin(code types.PaymentCategory, parent types.PaymentCategory) bool {
+	for _, ancestor := range s.categoryAncestors(code) {
+		if ancestor == parent {
+			return true
+		}
+	}
+	return false
+}
+
+//categoryNames возвращает все встречающиеся в платежах категории
+func (s *Service) categoryNames() map[types.PaymentCategory]struct{} {
+	names := make(map[types.PaymentCategory]struct{})
+	for _, payment := range s.repositories().Payments.All() {
+		names[payment.Category] = struct{}{}
+	}
+	return names
+}
+
+//insertCategory добавляет категорию в справочник
+func (s *Service) insertCategory(category *types.Category) {
+	if s.categories.byCode == nil {
+		s.categories.byCode = make(map[types.PaymentCategory]*types.Category)
+		s.categories.byAlias = make(map[string]types.PaymentCategory)
+	}
+	s.categories.byCode[category.Code] = category
+	for _, alias := range category.Aliases {
+		s.categories.byAlias[alias] = category.Code
+	}
+}
+
+//exportCategories записывает справочник в categories.dump, родительские категории раньше дочерних
+func (s *Service) exportCategories(dir string) error {
+	if len(s.categories.byCode) == 0 {
+		return nil
+	}
+
+	codes := make([]types.PaymentCategory, 0, len(s.categories.byCode))
+	for code := range s.categories.byCode {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		depthI, depthJ := len(s.categoryAncestors(codes[i])), len(s.categoryAncestors(codes[j]))
+		if depthI != depthJ {
+			return depthI < depthJ
+		}
+		return codes[i] < codes[j]
+	})
+
+	catData := make([]byte, 0)
+
+	for _, code := range codes {
+		category := s.categories.byCode[code]
+		str := string(category.Code) + (";") +
+			category.Name + (";") +
+			string(category.Parent) + (";") +
+			strings.Join(category.Aliases, ",") + ("\n")
+
+		catData = append(catData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/categories.dump", catData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importCategories читает справочник из categories.dump, заменяя категории с теми же кодами
+func (s *Service) importCategories(dir string) error {
+	path := dir + "/categories.dump"
+	catFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, catOperation := range strings.Split(string(catFile), "\n") {
+		if len(catOperation) == 0 {
+			break
+		}
+		catStr, err := dumpFields(path, i+1, catOperation, 4)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		category := &types.Category{
+			Code:   types.PaymentCategory(catStr[0]),
+			Name:   catStr[1],
+			Parent: types.PaymentCategory(catStr[2]),
+		}
+		if catStr[3] != "" {
+			category.Aliases = strings.Split(catStr[3], ",")
+		}
+
+		if old, ok := s.categories.byCode[category.Code]; ok {
+			for _, alias := range old.Aliases {
+				delete(s.categories.byAlias, alias)
+			}
+		}
+		s.insertCategory(category)
+	}
+	return nil
+}
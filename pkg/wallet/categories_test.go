@@ -0,0 +1,313 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func registerTestCategories(t *testing.T, s *testService) {
+	t.Helper()
+	for _, category := range []types.Category{
+		{Code: "food", Name: "Food"},
+		{Code: "restaurant", Name: "Restaurants", Parent: "food"},
+		{Code: "cafe", Name: "Cafes", Parent: "food", Aliases: []string{"Coffee"}},
+		{Code: "auto", Name: "Auto", Aliases: []string{"car"}},
+	} {
+		if _, err := s.RegisterCategory(category); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestService_RegisterCategory(t *testing.T) {
+	s := newTestService()
+	registerTestCategories(t, s)
+
+	_, err := s.RegisterCategory(types.Category{Code: "CAR"})
+	if !errors.Is(err, ErrCategoryExists) {
+		t.Errorf("RegisterCategory(): must return ErrCategoryExists, returned = %v", err)
+	}
+	_, err = s.RegisterCategory(types.Category{Code: "taxi", Aliases: []string{"Auto"}})
+	if !errors.Is(err, ErrCategoryExists) {
+		t.Errorf("RegisterCategory(): must return ErrCategoryExists, returned = %v", err)
+	}
+	_, err = s.RegisterCategory(types.Category{Code: "taxi", Parent: "transport"})
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("RegisterCategory(): must return ErrUnknownCategory, returned = %v", err)
+	}
+	_, err = s.RegisterCategory(types.Category{Code: " "})
+	if !errors.Is(err, ErrInvalidCategory) {
+		t.Errorf("RegisterCategory(): must return ErrInvalidCategory, returned = %v", err)
+	}
+
+	categories := s.Categories()
+	if len(categories) != 4 || categories[0].Code != "auto" || categories[1].Code != "cafe" {
+		t.Errorf("Categories(): wrong categories = %v", categories)
+	}
+	if !reflect.DeepEqual(categories[1].Aliases, []string{"coffee"}) {
+		t.Errorf("Categories(): aliases must be normalized = %v", categories[1].Aliases)
+	}
+}
+
+func TestService_Pay_validatesCategory(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := s.Pay(account.ID, 1_00, "Anything")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Category != "Anything" {
+		t.Errorf("Pay(): empty registry must keep category, category = %v", payment.Category)
+	}
+
+	registerTestCategories(t, s)
+	for _, tt := range []struct {
+		category types.PaymentCategory
+		want     types.PaymentCategory
+	}{
+		{"auto", "auto"},
+		{"Auto", "auto"},
+		{" car ", "auto"},
+		{"COFFEE", "cafe"},
+	} {
+		payment, err := s.Pay(account.ID, 1_00, tt.category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payment.Category != tt.want {
+			t.Errorf("Pay(%q): category = %v, want %v", tt.category, payment.Category, tt.want)
+		}
+	}
+
+	balance := s.account(t, account.ID).Balance
+	_, err = s.Pay(account.ID, 1_00, "bank")
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("Pay(): must return ErrUnknownCategory, returned = %v", err)
+	}
+	if got := s.account(t, account.ID).Balance; got != balance {
+		t.Errorf("Pay(): balance must not change, balance = %v", got)
+	}
+	_, err = s.Authorize(account.ID, 1_00, "bank")
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("Authorize(): must return ErrUnknownCategory, returned = %v", err)
+	}
+}
+
+func TestService_categoryRules(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestCategories(t, s)
+
+	err = s.SetFeeRules([]types.FeeRule{{Category: "Coffee", Fixed: 5}, {Category: "food", Fixed: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		category types.PaymentCategory
+		want     types.Money
+	}{
+		{"coffee", 5},
+		{"cafe", 5},
+		{"restaurant", 3},
+		{"car", 0},
+	} {
+		fee, err := s.CalculateFee(account.ID, 10_00, tt.category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fee != tt.want {
+			t.Errorf("CalculateFee(%q) = %v, want %v", tt.category, fee, tt.want)
+		}
+	}
+	payment, err := s.Pay(account.ID, 10_00, "coffee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, err := s.FindFeeByPaymentID(payment.ID)
+	if err != nil || fee.Amount != 5 {
+		t.Errorf("Pay(): fee must match the quote, fee = %v, err = %v", fee, err)
+	}
+	_, err = s.CalculateFee(account.ID, 10_00, "bank")
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("CalculateFee(): must return ErrUnknownCategory, returned = %v", err)
+	}
+	err = s.SetFeeRules([]types.FeeRule{{Category: "bank", Fixed: 5}})
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("SetFeeRules(): must return ErrUnknownCategory, returned = %v", err)
+	}
+
+	err = s.SetRewardRules([]types.RewardRule{{Kind: types.RewardCashback, Category: "FOOD", Rate: 1_000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err = s.Pay(account.ID, 10_00, "restaurant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Complete(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	rewards, err := s.RewardBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewards.Cashback != 1_00 {
+		t.Errorf("RewardBalance(): parent rule must apply to child, cashback = %v", rewards.Cashback)
+	}
+
+	err = s.SetSpendingLimits(account.ID, types.SpendingLimits{Categories: map[types.PaymentCategory]types.Money{"Food": 30_00}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := s.SpendingLimits(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(limits.Categories, map[types.PaymentCategory]types.Money{"food": 30_00}) {
+		t.Errorf("SpendingLimits(): categories must be resolved = %v", limits.Categories)
+	}
+	_, err = s.Pay(account.ID, 15_00, "coffee")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Category != "food" || limitErr.Remaining != 10_00 {
+		t.Errorf("Pay(): parent limit must apply to child, returned = %v", err)
+	}
+	err = s.SetSpendingLimits(account.ID, types.SpendingLimits{Categories: map[types.PaymentCategory]types.Money{"cafe": 1, "coffee": 2}})
+	if !errors.Is(err, ErrInvalidLimit) {
+		t.Errorf("SetSpendingLimits(): duplicate category must return ErrInvalidLimit, returned = %v", err)
+	}
+}
+
+func TestService_CategoryFilter(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, category := range []types.PaymentCategory{"food", "Coffee", "auto"} {
+		if _, err := s.Pay(account.ID, 1_00, category); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registerTestCategories(t, s)
+	for _, category := range []types.PaymentCategory{"restaurant", "car"} {
+		if _, err := s.Pay(account.ID, 1_00, category); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter, err := s.CategoryFilter("food")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments, err := s.FilterPaymentsByFn(filter, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 3 {
+		t.Errorf("CategoryFilter(): food must include children and aliases, payments = %v", payments)
+	}
+
+	filter, err = s.CategoryFilter("Car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments, err = s.FilterPaymentsByFn(filter, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 2 {
+		t.Errorf("CategoryFilter(): wrong auto payments = %v", payments)
+	}
+
+	_, err = s.CategoryFilter("bank")
+	if !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("CategoryFilter(): must return ErrUnknownCategory, returned = %v", err)
+	}
+}
+
+func TestService_CategoryTotals_rollup(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.addAccountWithBalance("+992000000002", 1_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerTestCategories(t, s)
+
+	for _, pay := range []struct {
+		amount   types.Money
+		category types.PaymentCategory
+	}{
+		{10_00, "food"},
+		{20_00, "restaurant"},
+		{30_00, "cafe"},
+		{40_00, "auto"},
+	} {
+		if _, err := s.Pay(account.ID, pay.amount, pay.category); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rejected, err := s.Pay(account.ID, 50_00, "cafe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(rejected.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pay(other.ID, 5_00, "cafe"); err != nil {
+		t.Fatal(err)
+	}
+
+	totals, err := s.AccountCategoryTotals(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[types.PaymentCategory]types.Money{
+		"food":       60_00,
+		"restaurant": 20_00,
+		"cafe":       30_00,
+		"auto":       40_00,
+	}
+	if !reflect.DeepEqual(totals, want) {
+		t.Errorf("AccountCategoryTotals(): totals = %v, want %v", totals, want)
+	}
+
+	want["food"] += 5_00
+	want["cafe"] += 5_00
+	totals = s.CategoryTotals()
+	if !reflect.DeepEqual(totals, want) {
+		t.Errorf("CategoryTotals(): totals = %v, want %v", totals, want)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported.Categories(), s.Categories()) {
+		t.Errorf("Import(): categories = %v, want %v", imported.Categories(), s.Categories())
+	}
+	totals = imported.CategoryTotals()
+	if !reflect.DeepEqual(totals, want) {
+		t.Errorf("Import(): totals = %v, want %v", totals, want)
+	}
+}
@@ -0,0 +1,70 @@
+package wallet
+
+import (
+	"strconv"
+	"time"
+)
+
+//Clock источник текущего времени. Подменяется в тестах.
+type Clock interface {
+	Now() time.Time
+}
+
+//SystemClock часы, возвращающие системное время
+type SystemClock struct{}
+
+//Now возвращает текущее системное время
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+//SetClock задаёт часы, по которым проставляются даты создания и изменения.
+//По умолчанию используется SystemClock.
+func (s *Service) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	s.clock = clock
+}
+
+func (s *Service) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+//formatTime записывает время для dump в наносекундах, нулевое время как 0
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+//parseTime читает время, записанное formatTime
+func parseTime(str string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nanos == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, nanos), nil
+}
+
+//parseTimes читает необязательные поля created и updated начиная с fields[from].
+//Старые dump без дат дают нулевое время.
+func parseTimes(fields []string, from int) (created time.Time, updated time.Time, err error) {
+	if len(fields) > from {
+		created, err = parseTime(fields[from])
+		if err != nil {
+			return
+		}
+	}
+	if len(fields) > from+1 {
+		updated, err = parseTime(fields[from+1])
+	}
+	return
+}
@@ -0,0 +1,152 @@
+package wallet
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestService_timestamps(t *testing.T) {
+	s := newTestService()
+	created := s.clock.Now()
+
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(time.Hour)
+	if err := s.Deposit(account.ID, 100_00); err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if !account.Created.Equal(created) || !account.Updated.Equal(created.Add(time.Hour)) {
+		t.Errorf("Deposit(): wrong account times created = %v, updated = %v", account.Created, account.Updated)
+	}
+
+	payment, err := s.Pay(account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !favorite.Created.Equal(s.clock.Now()) || !favorite.Updated.Equal(s.clock.Now()) {
+		t.Errorf("FavoritePayment(): wrong favorite times = %v", favorite)
+	}
+
+	s.clock.Add(time.Hour)
+	if err := s.Complete(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	payment = s.payment(t, payment.ID)
+	if !payment.Created.Equal(created.Add(time.Hour)) || !payment.Updated.Equal(created.Add(2*time.Hour)) {
+		t.Errorf("Complete(): wrong payment times created = %v, updated = %v", payment.Created, payment.Updated)
+	}
+	if !payment.History[0].At.Equal(created.Add(2 * time.Hour)) {
+		t.Errorf("Complete(): wrong history time = %v", payment.History[0].At)
+	}
+}
+
+func TestService_Export_timestamps(t *testing.T) {
+	s := newTestService()
+	Transactions(s)
+	s.clock.Add(time.Minute)
+	if err := s.Complete(s.repositories().Payments.All()[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, account := range s.repositories().Accounts.All() {
+		got, err := imported.FindAccountByID(account.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, account) {
+			t.Errorf("FindAccountByID(): wrong account returned = %v, want %v", got, account)
+		}
+	}
+	for _, payment := range s.repositories().Payments.All() {
+		got, err := imported.FindPaymentByID(payment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, payment) {
+			t.Errorf("FindPaymentByID(): wrong payment returned = %v, want %v", got, payment)
+		}
+	}
+	for _, favorite := range s.repositories().Favorites.All() {
+		got, err := imported.GetFavoriteByID(favorite.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, favorite) {
+			t.Errorf("GetFavoriteByID(): wrong favorite returned = %v, want %v", got, favorite)
+		}
+	}
+}
+
+func TestService_Import_withoutTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"accounts.dump":  "1;1111;440\n",
+		"payments.dump":  "p1;1;60;bank;INPROGRESS\n",
+		"favorites.dump": "f1;1;50_for_bank;60;bank\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newTestService()
+	if err := s.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.FindPaymentByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.GetFavoriteByID("f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 440 || payment.Amount != 60 || favorite.Name != "50_for_bank" {
+		t.Errorf("Import(): wrong data imported account = %v, payment = %v, favorite = %v", account, payment, favorite)
+	}
+	if !account.Created.IsZero() || !payment.Created.IsZero() || !favorite.Created.IsZero() {
+		t.Errorf("Import(): old dumps must give zero times")
+	}
+}
+
+func TestService_defaultClock(t *testing.T) {
+	s := &Service{}
+	before := time.Now()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Created.Before(before) || account.Created.After(time.Now()) {
+		t.Errorf("RegisterAccount(): wrong created time = %v", account.Created)
+	}
+
+	s.SetClock(SystemClock{})
+	if s.now().IsZero() {
+		t.Error("SystemClock.Now(): must return current time")
+	}
+}
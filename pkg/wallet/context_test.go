@@ -0,0 +1,168 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+//paymentsService создаёт сервис с payments платежами по 1 дирам
+func paymentsService(t *testing.T, payments int) *testService {
+	t.Helper()
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", types.Money(payments))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < payments; i++ {
+		if _, err := s.Pay(account.ID, 1, "auto"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+//waitGoroutines ждёт, пока число горутин не вернётся к want
+func waitGoroutines(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > want {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked: %d, want %d", runtime.NumGoroutine(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestService_SumPaymentsContext(t *testing.T) {
+	s := paymentsService(t, 1_000)
+
+	sum, err := s.SumPaymentsContext(context.Background(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != s.SumPayments(1) {
+		t.Errorf("SumPaymentsContext(): sum = %v, want %v", sum, s.SumPayments(1))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sum, err = s.SumPaymentsContext(ctx, 4)
+	if !errors.Is(err, context.Canceled) || sum != 0 {
+		t.Errorf("SumPaymentsContext(): must return context.Canceled, returned = %v, %v", sum, err)
+	}
+}
+
+func TestService_FilterPaymentsByFnContext_cancelled(t *testing.T) {
+	s := paymentsService(t, 1_000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	visited := make(chan struct{}, 1_000)
+	payments, err := s.FilterPaymentsByFnContext(ctx, func(payment types.Payment) bool {
+		visited <- struct{}{}
+		cancel()
+		return true
+	}, 1)
+	if !errors.Is(err, context.Canceled) || payments != nil {
+		t.Errorf("FilterPaymentsByFnContext(): must return context.Canceled, returned = %v", err)
+	}
+	if len(visited) != 1 {
+		t.Errorf("FilterPaymentsByFnContext(): must stop after cancel, visited = %v", len(visited))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = s.FilterPaymentsContext(ctx, 1, 2)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FilterPaymentsContext(): must return context.DeadlineExceeded, returned = %v", err)
+	}
+}
+
+func TestService_SumPaymentsWithProgressContext_abandoned(t *testing.T) {
+	s := paymentsService(t, 1_000)
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progress := s.SumPaymentsWithProgressContext(ctx)
+	cancel()
+	waitGoroutines(t, goroutines)
+
+	for range progress {
+	}
+	if ctx.Err() == nil {
+		t.Error("SumPaymentsWithProgressContext(): context must be cancelled")
+	}
+}
+
+func TestMergeContext_drainsSenders(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	channels := make([]<-chan types.Progress, 3)
+	for i := range channels {
+		ch := make(chan types.Progress)
+		go func() {
+			defer close(ch)
+			for j := 0; j < 10; j++ {
+				ch <- types.Progress{Part: j}
+			}
+		}()
+		channels[i] = ch
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	merged := MergeContext(ctx, channels)
+	<-merged
+	cancel()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-merged:
+			if !ok {
+				waitGoroutines(t, goroutines)
+				return
+			}
+		case <-timeout:
+			t.Fatal("MergeContext(): channel must be closed after cancel")
+		}
+	}
+}
+
+func TestService_ExportContext_cancelled(t *testing.T) {
+	s := newTestService()
+	_, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	dir := t.TempDir()
+	err = s.ExportContext(ctx, dir)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExportContext(): must return context.Canceled, returned = %v", err)
+	}
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := newTestService()
+	err = imported.ImportContext(ctx, dir)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ImportContext(): must return context.Canceled, returned = %v", err)
+	}
+	if _, err := imported.FindAccountByID(1); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ImportContext(): nothing must be imported, returned = %v", err)
+	}
+
+	payments := []types.Payment{{ID: "1"}, {ID: "2"}}
+	err = s.HistoryToFilesContext(ctx, payments, t.TempDir(), 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("HistoryToFilesContext(): must return context.Canceled, returned = %v", err)
+	}
+}
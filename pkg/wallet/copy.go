@@ -0,0 +1,98 @@
+package wallet
+
+import "github.com/rgsgit/wallet/pkg/types"
+
+//Публичные методы возвращают копии сущностей: сервис продолжает менять свои
+//данные под блокировкой, и чтение по возвращённому указателю было бы гонкой.
+//Функции принимают результат внутреннего метода целиком, чтобы его можно было
+//вернуть одной строкой: return copyPayment(s.pay(accountID, amount, category)).
+
+func copyAccount(account *types.Account, err error) (*types.Account, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *account
+	return &result, nil
+}
+
+func copyPayment(payment *types.Payment, err error) (*types.Payment, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *payment
+	result.History = append([]types.StatusChange(nil), payment.History...)
+	return &result, nil
+}
+
+func copyFavorite(favorite *types.Favorite, err error) (*types.Favorite, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *favorite
+	return &result, nil
+}
+
+func copyTransfer(transfer *types.Transfer, err error) (*types.Transfer, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *transfer
+	return &result, nil
+}
+
+func copyDeposit(deposit *types.Deposit, err error) (*types.Deposit, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *deposit
+	return &result, nil
+}
+
+func copyRefund(refund *types.Refund, err error) (*types.Refund, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *refund
+	return &result, nil
+}
+
+func copyHold(hold *types.Hold, err error) (*types.Hold, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *hold
+	return &result, nil
+}
+
+func copyFee(fee *types.Fee, err error) (*types.Fee, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *fee
+	return &result, nil
+}
+
+func copySchedule(schedule *types.Schedule, err error) (*types.Schedule, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *schedule
+	return &result, nil
+}
+
+func copyBudget(budget *types.Budget, err error) (*types.Budget, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *budget
+	return &result, nil
+}
+
+func copyCategory(category *types.Category, err error) (*types.Category, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *category
+	result.Aliases = append([]string(nil), category.Aliases...)
+	return &result, nil
+}
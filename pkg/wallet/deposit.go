@@ -0,0 +1,223 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrDepositNotFound = errors.New("deposit not found")
+
+//dumpReplacer убирает из текстовых полей разделители формата dump
+var dumpReplacer = strings.NewReplacer(";", " ", "\n", " ", "\r", " ")
+
+//DepositFrom пополняет счёт с указанием источника и возвращает запись о пополнении
+func (s *Service) DepositFrom(accountID int64, amount types.Money, source string, opts ...CallOption) (_ *types.Deposit, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	return copyDeposit(s.deposit(accountID, amount, source))
+}
+
+func (s *Service) deposit(accountID int64, amount types.Money, source string) (*types.Deposit, error) {
+	if amount <= 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, AccountID: accountID, Amount: amount}
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	deposit := &types.Deposit{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Amount:    amount,
+		Source:    dumpReplacer.Replace(source),
+		Status:    types.PaymentStatusOk,
+		Created:   now,
+		Updated:   now,
+	}
+	s.insertDeposit(deposit)
+
+	//зачисление средств
+	s.post(deposit.ID, LedgerCashAccountID, account.ID, amount)
+	s.publish(types.Event{
+		Type:      types.EventDeposited,
+		AccountID: account.ID,
+		EntityID:  deposit.ID,
+		Amount:    amount,
+	})
+
+	return deposit, nil
+}
+
+//FindDepositByID поиск пополнения по ID
+func (s *Service) FindDepositByID(depositID string) (*types.Deposit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return copyDeposit(s.findDepositByID(depositID))
+}
+
+func (s *Service) findDepositByID(depositID string) (*types.Deposit, error) {
+	deposit, ok := s.indexes().depositsByID[depositID]
+	if !ok {
+		return nil, &Error{Err: ErrDepositNotFound, ID: depositID}
+	}
+	return deposit, nil
+}
+
+//ExportAccountDeposits возвращает все пополнения конкретного аккаунта
+func (s *Service) ExportAccountDeposits(accountID int64) ([]types.Deposit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	deposits := []types.Deposit{}
+	for _, deposit := range s.indexes().depositsByAccount[accountID] {
+		deposits = append(deposits, *deposit)
+	}
+	return deposits, nil
+}
+
+//ReverseDeposit отменяет ошибочное пополнение и списывает деньги обратно
+func (s *Service) ReverseDeposit(depositID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	deposit, err := s.findDepositByID(depositID)
+	if err != nil {
+		return err
+	}
+
+	if deposit.Status == types.PaymentStatusFail {
+		return nil
+	}
+
+	account, err := s.findAccountByID(deposit.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if !s.canDebit(account, deposit.Amount) {
+		return &Error{Err: ErrNotEnoughBalance, AccountID: account.ID, ID: deposit.ID, Amount: deposit.Amount}
+	}
+
+	s.post(deposit.ID, account.ID, LedgerCashAccountID, deposit.Amount)
+	deposit.Status = types.PaymentStatusFail
+	deposit.Updated = s.now()
+	s.publish(types.Event{
+		Type:      types.EventDepositReversed,
+		AccountID: account.ID,
+		EntityID:  deposit.ID,
+		Amount:    deposit.Amount,
+	})
+
+	return nil
+}
+
+//insertDeposit добавляет пополнение в хранилище и индексы
+func (s *Service) insertDeposit(deposit *types.Deposit) {
+	idx := s.indexes()
+	s.deposits = append(s.deposits, deposit)
+	idx.depositsByID[deposit.ID] = deposit
+	idx.depositsByAccount[deposit.AccountID] = append(idx.depositsByAccount[deposit.AccountID], deposit)
+}
+
+//exportDeposits записывает пополнения в deposits.dump
+func (s *Service) exportDeposits(dir string) error {
+	if len(s.deposits) == 0 {
+		return nil
+	}
+
+	depData := make([]byte, 0)
+
+	for _, deposit := range s.deposits {
+		str := deposit.ID + (";") +
+			strconv.FormatInt(deposit.AccountID, 10) + (";") +
+			strconv.FormatInt(int64(deposit.Amount), 10) + (";") +
+			deposit.Source + (";") +
+			string(deposit.Status) + (";") +
+			formatTime(deposit.Created) + (";") +
+			formatTime(deposit.Updated) + ("\n")
+
+		depData = append(depData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/deposits.dump", depData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importDeposits читает пополнения из deposits.dump
+func (s *Service) importDeposits(dir string) error {
+	path := dir + "/deposits.dump"
+	depFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, depOperation := range strings.Split(string(depFile), "\n") {
+		if len(depOperation) == 0 {
+			break
+		}
+		depStr, err := dumpFields(path, i+1, depOperation, 5)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := depStr[0]
+		accountID, err := strconv.ParseInt(depStr[1], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		amount, err := strconv.ParseInt(depStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		source := depStr[3]
+		status := types.PaymentStatus(depStr[4])
+		created, updated, err := parseTimes(depStr, 5)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		depFind, _ := s.findDepositByID(id)
+		if depFind != nil {
+			depFind.Amount = types.Money(amount)
+			depFind.Source = source
+			depFind.Status = status
+			depFind.Created = created
+			depFind.Updated = updated
+			continue
+		}
+		s.insertDeposit(&types.Deposit{
+			ID:        id,
+			AccountID: accountID,
+			Amount:    types.Money(amount),
+			Source:    source,
+			Status:    status,
+			Created:   created,
+			Updated:   updated,
+		})
+	}
+	return nil
+}
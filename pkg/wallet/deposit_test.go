@@ -0,0 +1,145 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_DepositFrom_success(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deposit, err := s.DepositFrom(account.ID, 100_00, "card;4111\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deposit.Source != "card 4111 " || deposit.Status != types.PaymentStatusOk {
+		t.Errorf("DepositFrom(): wrong deposit returned = %v", deposit)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 100_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, 100_00)
+	}
+
+	got, err := s.FindDepositByID(deposit.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, deposit) {
+		t.Errorf("FindDepositByID(): wrong deposit returned = %v", got)
+	}
+
+	entries, err := s.AccountEntries(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].OperationID != deposit.ID {
+		t.Errorf("AccountEntries(): wrong entries returned = %v", entries)
+	}
+}
+
+func TestService_ExportAccountDeposits(t *testing.T) {
+	s := newTestService()
+	Transactions(s)
+	if err := s.Deposit(1, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	deposits, err := s.ExportAccountDeposits(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deposits) != 2 || deposits[0].Amount != 500 || deposits[1].Amount != 50 {
+		t.Errorf("ExportAccountDeposits(): wrong deposits returned = %v", deposits)
+	}
+
+	_, err = s.ExportAccountDeposits(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ExportAccountDeposits(): must return ErrAccountNotFound, returned = %v", err)
+	}
+}
+
+func TestService_ReverseDeposit(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposit, err := s.DepositFrom(account.ID, 100_00, "card")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pay(account.ID, 90_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ReverseDeposit(deposit.ID); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("ReverseDeposit(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+
+	if err := s.Deposit(account.ID, 90_00); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReverseDeposit(deposit.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReverseDeposit(deposit.ID); err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	deposit, err = s.FindDepositByID(deposit.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 0 || deposit.Status != types.PaymentStatusFail {
+		t.Errorf("ReverseDeposit(): wrong result balance = %v, status = %v", account.Balance, deposit.Status)
+	}
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+
+	if err := s.ReverseDeposit("unknown"); !errors.Is(err, ErrDepositNotFound) {
+		t.Errorf("ReverseDeposit(): must return ErrDepositNotFound, returned = %v", err)
+	}
+}
+
+func TestService_Export_deposits(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposit, err := s.DepositFrom(account.ID, 100_00, "card")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := imported.FindDepositByID(deposit.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, deposit) {
+		t.Errorf("FindDepositByID(): wrong deposit returned = %v", got)
+	}
+	if err := imported.ReverseDeposit(deposit.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := imported.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
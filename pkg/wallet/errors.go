@@ -0,0 +1,200 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrInvalidDump = errors.New("invalid dump")
+
+//Code стабильный код ошибки для внешнего API, не меняется вместе с текстом ошибки
+type Code string
+
+//Коды ошибок сервиса
+const (
+	CodeUnknown               Code = "UNKNOWN"
+	CodePhoneRegistered       Code = "PHONE_REGISTERED"
+	CodeAmountMustBePositive  Code = "AMOUNT_MUST_BE_POSITIVE"
+	CodeAccountNotFound       Code = "ACCOUNT_NOT_FOUND"
+	CodeNotEnoughBalance      Code = "NOT_ENOUGH_BALANCE"
+	CodePaymentNotFound       Code = "PAYMENT_NOT_FOUND"
+	CodeFavoriteNotFound      Code = "FAVORITE_NOT_FOUND"
+	CodeFavoriteNameExists    Code = "FAVORITE_NAME_EXISTS"
+	CodeInvalidTransition     Code = "INVALID_TRANSITION"
+	CodeDepositNotFound       Code = "DEPOSIT_NOT_FOUND"
+	CodeTransferNotFound      Code = "TRANSFER_NOT_FOUND"
+	CodeSelfTransfer          Code = "SELF_TRANSFER"
+	CodePhoneNotRegistered    Code = "PHONE_NOT_REGISTERED"
+	CodeInvalidPhone          Code = "INVALID_PHONE"
+	CodeRefundExceedsPayment  Code = "REFUND_EXCEEDS_PAYMENT"
+	CodeRefundNotFound        Code = "REFUND_NOT_FOUND"
+	CodeIdempotencyKeyReused  Code = "IDEMPOTENCY_KEY_REUSED"
+	CodeInvalidIdempotencyKey Code = "INVALID_IDEMPOTENCY_KEY"
+	CodeLimitExceeded         Code = "LIMIT_EXCEEDED"
+	CodeInvalidLimit          Code = "INVALID_LIMIT"
+	CodeInvalidOverdraftLimit Code = "INVALID_OVERDRAFT_LIMIT"
+	CodeScheduleNotFound      Code = "SCHEDULE_NOT_FOUND"
+	CodeInvalidSchedule       Code = "INVALID_SCHEDULE"
+	CodeScheduleCancelled     Code = "SCHEDULE_CANCELLED"
+	CodeHoldNotFound          Code = "HOLD_NOT_FOUND"
+	CodeHoldNotActive         Code = "HOLD_NOT_ACTIVE"
+	CodeHoldExpired           Code = "HOLD_EXPIRED"
+	CodeCaptureExceedsHold    Code = "CAPTURE_EXCEEDS_HOLD"
+	CodeInvalidFeeRule        Code = "INVALID_FEE_RULE"
+	CodeFeeNotFound           Code = "FEE_NOT_FOUND"
+	CodeInvalidRewardRule     Code = "INVALID_REWARD_RULE"
+	CodeNoRewards             Code = "NO_REWARDS"
+	CodeUnknownCategory       Code = "UNKNOWN_CATEGORY"
+	CodeCategoryExists        Code = "CATEGORY_EXISTS"
+	CodeInvalidCategory       Code = "INVALID_CATEGORY"
+	CodeBudgetNotFound        Code = "BUDGET_NOT_FOUND"
+	CodeLedgerMismatch        Code = "LEDGER_MISMATCH"
+	CodeAuditTampered         Code = "AUDIT_TAMPERED"
+	CodeAuditDisabled         Code = "AUDIT_DISABLED"
+	CodeAuditWrite            Code = "AUDIT_WRITE"
+	CodeRepositorySync        Code = "REPOSITORY_SYNC"
+	CodeInvalidDump           Code = "INVALID_DUMP"
+)
+
+//errorCodes коды ошибок-сентинелов. Порядок важен: CodeOf берёт первый подходящий.
+var errorCodes = []struct {
+	err  error
+	code Code
+}{
+	{ErrPhoneRegistred, CodePhoneRegistered},
+	{ErrAmmountMustBePositive, CodeAmountMustBePositive},
+	{ErrAccountNotFound, CodeAccountNotFound},
+	{ErrNotEnoughBalance, CodeNotEnoughBalance},
+	{ErrPaymentNotFound, CodePaymentNotFound},
+	{ErrFavoriteNotFound, CodeFavoriteNotFound},
+	{ErrFavoriteNameExists, CodeFavoriteNameExists},
+	{ErrInvalidTransition, CodeInvalidTransition},
+	{ErrDepositNotFound, CodeDepositNotFound},
+	{ErrTransferNotFound, CodeTransferNotFound},
+	{ErrSelfTransfer, CodeSelfTransfer},
+	{ErrPhoneNotRegistred, CodePhoneNotRegistered},
+	{ErrInvalidPhone, CodeInvalidPhone},
+	{ErrRefundExceedsPayment, CodeRefundExceedsPayment},
+	{ErrRefundNotFound, CodeRefundNotFound},
+	{ErrIdempotencyKeyReused, CodeIdempotencyKeyReused},
+	{ErrInvalidIdempotencyKey, CodeInvalidIdempotencyKey},
+	{ErrLimitExceeded, CodeLimitExceeded},
+	{ErrInvalidLimit, CodeInvalidLimit},
+	{ErrInvalidOverdraftLimit, CodeInvalidOverdraftLimit},
+	{ErrScheduleNotFound, CodeScheduleNotFound},
+	{ErrInvalidSchedule, CodeInvalidSchedule},
+	{ErrScheduleCancelled, CodeScheduleCancelled},
+	{ErrHoldNotFound, CodeHoldNotFound},
+	{ErrHoldNotActive, CodeHoldNotActive},
+	{ErrHoldExpired, CodeHoldExpired},
+	{ErrCaptureExceedsHold, CodeCaptureExceedsHold},
+	{ErrInvalidFeeRule, CodeInvalidFeeRule},
+	{ErrFeeNotFound, CodeFeeNotFound},
+	{ErrInvalidRewardRule, CodeInvalidRewardRule},
+	{ErrNoRewards, CodeNoRewards},
+	{ErrUnknownCategory, CodeUnknownCategory},
+	{ErrCategoryExists, CodeCategoryExists},
+	{ErrInvalidCategory, CodeInvalidCategory},
+	{ErrBudgetNotFound, CodeBudgetNotFound},
+	{ErrLedgerMismatch, CodeLedgerMismatch},
+	{ErrAuditTampered, CodeAuditTampered},
+	{ErrAuditDisabled, CodeAuditDisabled},
+	{ErrAuditWrite, CodeAuditWrite},
+	{ErrRepositorySync, CodeRepositorySync},
+	{ErrInvalidDump, CodeInvalidDump},
+}
+
+//Error ошибка сервиса с контекстом. Err - сентинел, по которому определяется код,
+//Cause - исходная ошибка, из-за которой возникла эта.
+//errors.Is(err, Err) возвращает true, errors.Is и errors.As проверяют и Cause.
+type Error struct {
+	Err       error
+	AccountID int64
+	//ID платежа, перевода, избранного и т.п., к которому относится ошибка
+	ID     string
+	Amount types.Money
+	Cause  error
+}
+
+func (e *Error) Error() string {
+	details := []string{}
+	if e.AccountID != 0 {
+		details = append(details, "account "+strconv.FormatInt(e.AccountID, 10))
+	}
+	if e.ID != "" {
+		details = append(details, "id "+e.ID)
+	}
+	if e.Amount != 0 {
+		details = append(details, "amount "+strconv.FormatInt(int64(e.Amount), 10))
+	}
+
+	message := e.Err.Error()
+	if len(details) > 0 {
+		message += ": " + strings.Join(details, ", ")
+	}
+	if e.Cause != nil {
+		message += ": " + e.Cause.Error()
+	}
+	return message
+}
+
+//Is позволяет сравнивать ошибку с сентинелом Err
+func (e *Error) Is(target error) bool {
+	return target == e.Err
+}
+
+//Unwrap возвращает исходную ошибку
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+//Code возвращает код ошибки
+func (e *Error) Code() Code {
+	return sentinelCode(e.Err)
+}
+
+//CodeOf возвращает код ошибки сервиса или CodeUnknown для посторонних ошибок и nil
+func CodeOf(err error) Code {
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Code()
+	}
+	for _, item := range errorCodes {
+		if errors.Is(err, item.err) {
+			return item.code
+		}
+	}
+	return CodeUnknown
+}
+
+func sentinelCode(err error) Code {
+	for _, item := range errorCodes {
+		if item.err == err {
+			return item.code
+		}
+	}
+	return CodeUnknown
+}
+
+//ruleError ошибка err в правиле номер i (с нуля) из списка list, reason объясняет, что не так
+func ruleError(err error, list string, i int, reason string) error {
+	return &Error{Err: err, ID: list + "[" + strconv.Itoa(i) + "]", Cause: errors.New(reason)}
+}
+
+//dumpError ошибка ErrInvalidDump в строке line (с единицы) файла path
+func dumpError(path string, line int, cause error) error {
+	return &Error{Err: ErrInvalidDump, ID: path + ":" + strconv.Itoa(line), Cause: cause}
+}
+
+//dumpFields делит строку line файла path на поля и проверяет, что их не меньше want
+func dumpFields(path string, line int, str string, want int) ([]string, error) {
+	fields := strings.Split(str, ";")
+	if len(fields) < want {
+		return nil, dumpError(path, line, fmt.Errorf("%d fields, want at least %d", len(fields), want))
+	}
+	return fields, nil
+}
@@ -0,0 +1,222 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_errors_context(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, 20_00, "auto")
+	var serviceErr *Error
+	if !errors.As(err, &serviceErr) || !errors.Is(err, ErrNotEnoughBalance) {
+		t.Fatalf("Pay(): must return *Error with ErrNotEnoughBalance, returned = %v", err)
+	}
+	if serviceErr.Code() != CodeNotEnoughBalance || serviceErr.AccountID != account.ID || serviceErr.Amount != 20_00 {
+		t.Errorf("Pay(): wrong error context = %+v", serviceErr)
+	}
+	if err.Error() != "not enough balance: account "+strconv.FormatInt(account.ID, 10)+", amount 2000" {
+		t.Errorf("Pay(): wrong error message = %v", err)
+	}
+
+	_, err = s.FavoritePayment("unknown", "auto")
+	if !errors.As(err, &serviceErr) || serviceErr.ID != "unknown" || CodeOf(err) != CodePaymentNotFound {
+		t.Errorf("FavoritePayment(): must return payment id, returned = %v", err)
+	}
+}
+
+func TestService_errors_limitAndTransition(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSpendingLimits(account.ID, types.SpendingLimits{Daily: 10_00}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, 20_00, "auto")
+	var serviceErr *Error
+	var limitErr *LimitError
+	if !errors.As(err, &serviceErr) || serviceErr.Code() != CodeLimitExceeded || !errors.As(err, &limitErr) {
+		t.Fatalf("Pay(): must return *Error with LimitError cause, returned = %v", err)
+	}
+	if serviceErr.AccountID != account.ID || serviceErr.Amount != 20_00 || limitErr.Kind != LimitDaily {
+		t.Errorf("Pay(): wrong error context = %+v, cause = %+v", serviceErr, limitErr)
+	}
+
+	payment, err := s.Pay(account.ID, 5_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Complete(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Complete(payment.ID)
+	var transitionErr *TransitionError
+	if !errors.As(err, &serviceErr) || serviceErr.Code() != CodeInvalidTransition || !errors.As(err, &transitionErr) {
+		t.Fatalf("Complete(): must return *Error with TransitionError cause, returned = %v", err)
+	}
+	if serviceErr.ID != payment.ID || transitionErr.From != types.PaymentStatusOk {
+		t.Errorf("Complete(): wrong error context = %+v, cause = %+v", serviceErr, transitionErr)
+	}
+}
+
+func TestService_errors_validation(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterCategory(types.Category{Code: "food", Aliases: []string{"groceries"}}); err != nil {
+		t.Fatal(err)
+	}
+	registerCategory := func(category types.Category) error {
+		_, err := s.RegisterCategory(category)
+		return err
+	}
+	_, phoneErr := s.TransferToPhone(account.ID, "+-()", 1)
+	_, scheduleErr := s.ScheduleFavorite(favorite.ID, types.ScheduleWeekly, 7)
+
+	tests := []struct {
+		name string
+		err  error
+		want Error
+	}{
+		{"SetFeeRules", s.SetFeeRules([]types.FeeRule{{}, {Fixed: -1}}), Error{Err: ErrInvalidFeeRule, ID: "fee_rules[1]"}},
+		{"SetRewardRules", s.SetRewardRules([]types.RewardRule{{Kind: "gift"}}), Error{Err: ErrInvalidRewardRule, ID: "reward_rules[0]"}},
+		{"SetPointValue", s.SetPointValue(-5), Error{Err: ErrAmmountMustBePositive, ID: "point_value", Amount: -5}},
+		{"SetSpendingLimits", s.SetSpendingLimits(account.ID, types.SpendingLimits{Monthly: -1}),
+			Error{Err: ErrInvalidLimit, AccountID: account.ID, ID: LimitMonthly, Amount: -1}},
+		{"SetSpendingLimits duplicate", s.SetSpendingLimits(account.ID, types.SpendingLimits{
+			Categories: map[types.PaymentCategory]types.Money{"groceries": 1, "food": 1},
+		}), Error{Err: ErrInvalidLimit, AccountID: account.ID}},
+		{"SetOverdraftLimit", s.SetOverdraftLimit(account.ID, -1), Error{Err: ErrInvalidOverdraftLimit, AccountID: account.ID, Amount: -1}},
+		{"RegisterCategory code", registerCategory(types.Category{Code: "FOOD"}), Error{Err: ErrCategoryExists, ID: "food"}},
+		{"RegisterCategory alias", registerCategory(types.Category{Code: "market", Aliases: []string{"Groceries"}}), Error{Err: ErrCategoryExists, ID: "groceries"}},
+		{"RegisterCategory parent", registerCategory(types.Category{Code: "taxi", Parent: "transport"}), Error{Err: ErrUnknownCategory, ID: "transport"}},
+		{"VerifyAudit", s.VerifyAudit(), Error{Err: ErrAuditDisabled}},
+		{"TransferToPhone", phoneErr, Error{Err: ErrInvalidPhone, AccountID: account.ID, ID: "+-()"}},
+		{"ScheduleFavorite", scheduleErr, Error{Err: ErrInvalidSchedule, ID: favorite.ID}},
+	}
+
+	for _, tt := range tests {
+		var serviceErr *Error
+		if !errors.As(tt.err, &serviceErr) || serviceErr.Err != tt.want.Err {
+			t.Errorf("%v(): must return *Error with %v, returned = %v", tt.name, tt.want.Err, tt.err)
+			continue
+		}
+		if serviceErr.AccountID != tt.want.AccountID || tt.want.ID != "" && serviceErr.ID != tt.want.ID || serviceErr.Amount != tt.want.Amount {
+			t.Errorf("%v(): wrong error context = %+v", tt.name, serviceErr)
+		}
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	cause := errors.New("disk is full")
+	tests := []struct {
+		err  error
+		code Code
+	}{
+		{nil, CodeUnknown},
+		{cause, CodeUnknown},
+		{ErrAccountNotFound, CodeAccountNotFound},
+		{&Error{Err: ErrInvalidDump, Cause: ErrAccountNotFound}, CodeInvalidDump},
+		{&LimitError{Kind: LimitDaily}, CodeLimitExceeded},
+		{&TransitionError{}, CodeInvalidTransition},
+	}
+	for _, tt := range tests {
+		if code := CodeOf(tt.err); code != tt.code {
+			t.Errorf("CodeOf(%v) = %v, want %v", tt.err, code, tt.code)
+		}
+	}
+
+	err := &Error{Err: ErrInvalidDump, ID: "accounts.dump", Cause: cause}
+	if !errors.Is(err, ErrInvalidDump) || !errors.Is(err, cause) || errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Error: wrong errors.Is result for %v", err)
+	}
+	if errors.Unwrap(err) != cause {
+		t.Errorf("Error: Unwrap must return cause, returned = %v", errors.Unwrap(err))
+	}
+}
+
+func TestService_ImportFromFile_invalidDump(t *testing.T) {
+	s := newTestService()
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	if err := os.WriteFile(path, []byte("1;+992000000001;abc|"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.ImportFromFile(path)
+	var numErr *strconv.NumError
+	if !errors.Is(err, ErrInvalidDump) || !errors.As(err, &numErr) {
+		t.Errorf("ImportFromFile(): must return ErrInvalidDump with cause, returned = %v", err)
+	}
+}
+
+//importDump записывает data в файл name и импортирует каталог в новый сервис
+func importDump(t *testing.T, name string, data string) error {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return newTestService().Import(dir)
+}
+
+func TestService_Import_invalidNumbers(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		short bool
+	}{
+		{name: "accounts.dump", data: "1;+992000000001;abc\n"},
+		{name: "payments.dump", data: "p1;1;10;auto;OK\np2;x;10;auto;OK\n"},
+		{name: "favorites.dump", data: "f1;1;name;abc;auto\n"},
+		{name: "limits.dump", data: "1;100;200;auto=x\n"},
+		{name: "budgets.dump", data: "1;food;abc;;;;0\n"},
+		{name: "transfers.dump", data: "t1;1;2\n", short: true},
+		{name: "ledger.dump", data: "e1;op1;1\n", short: true},
+		{name: "deposits.dump", data: "d1;1;100\n", short: true},
+		{name: "refunds.dump", data: "r1;p1\n", short: true},
+		{name: "idempotency.dump", data: "key-1;pay;1\n", short: true},
+		{name: "limits.dump", data: "1;100\n", short: true},
+		{name: "schedules.dump", data: "s1;f1;1;DAILY\n", short: true},
+		{name: "favorites_deleted.dump", data: "f1\n", short: true},
+		{name: "holds.dump", data: "h1;1;100;auto\n", short: true},
+		{name: "fees.dump", data: "f1;p1;1\n", short: true},
+		{name: "rewards.dump", data: "r1;1;CASHBACK\n", short: true},
+		{name: "categories.dump", data: "auto\n", short: true},
+		{name: "budgets.dump", data: "1;auto;100\n", short: true},
+	}
+	for _, tt := range tests {
+		err := importDump(t, tt.name, tt.data)
+		var walletErr *Error
+		var numErr *strconv.NumError
+		if !errors.As(err, &walletErr) || CodeOf(err) != CodeInvalidDump || errors.Unwrap(err) == nil {
+			t.Errorf("Import(): %v must return ErrInvalidDump with cause, returned = %v", tt.name, err)
+			continue
+		}
+		if tt.short && !strings.Contains(err.Error(), "fields, want at least") || !tt.short && !errors.As(err, &numErr) {
+			t.Errorf("Import(): %v wrong cause = %v", tt.name, errors.Unwrap(err))
+		}
+		if !strings.HasSuffix(walletErr.ID, tt.name+":"+strconv.Itoa(strings.Count(tt.data, "\n"))) {
+			t.Errorf("Import(): %v error must point to the line, id = %v", tt.name, walletErr.ID)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package wallet
+
+import (
+	"sync"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+//EventHandler обработчик доменных событий
+type EventHandler func(event types.Event)
+
+//EventFilter отбирает события для подписчика. Пустой Types подходит под любые
+//события, нулевой AccountID - под любой аккаунт.
+type EventFilter struct {
+	Types     []types.EventType
+	AccountID int64
+}
+
+//match проверяет, что событие подходит под фильтр
+func (f EventFilter) match(event types.Event) bool {
+	if f.AccountID != 0 && f.AccountID != event.AccountID && f.AccountID != event.CounterAccountID {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, eventType := range f.Types {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+//subscriber подписчик на события. У асинхронного подписчика есть очередь events.
+type subscriber struct {
+	filter  EventFilter
+	handler EventHandler
+	events  chan types.Event
+	done    chan struct{}
+	once    sync.Once
+}
+
+//eventBus очередь событий и подписчики.
+//События копятся в pending под блокировкой сервиса и доставляются после её снятия
+//одним горутином за раз (draining), поэтому все подписчики получают их в порядке публикации.
+type eventBus struct {
+	mu          sync.Mutex
+	seq         uint64
+	pending     []types.Event
+	draining    bool
+	subscribers []*subscriber
+}
+
+//Subscribe подписывает handler на события. Обработчик вызывается синхронно
+//после завершения изменившего данные метода и может вызывать методы сервиса.
+//Возвращает функцию отписки.
+func (s *Service) Subscribe(filter EventFilter, handler EventHandler) func() {
+	sub := &subscriber{filter: filter, handler: handler}
+	return s.events.subscribe(sub)
+}
+
+//SubscribeAsync подписывает handler на события с очередью на buffer событий.
+//Обработчик вызывается в отдельном горутине по одному событию в порядке публикации.
+//Когда очередь заполнена, публикующий метод ждёт освобождения места.
+//После отписки события, оставшиеся в очереди, не доставляются.
+func (s *Service) SubscribeAsync(filter EventFilter, buffer int, handler EventHandler) func() {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &subscriber{
+		filter:  filter,
+		handler: handler,
+		events:  make(chan types.Event, buffer),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case event := <-sub.events:
+				sub.handler(event)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+	return s.events.subscribe(sub)
+}
+
+func (b *eventBus) subscribe(sub *subscriber) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, sub)
+	return func() {
+		b.unsubscribe(sub)
+	}
+}
+
+func (b *eventBus) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, item := range b.subscribers {
+		if item == sub {
+			b.subscribers = append(b.subscribers[:i:i], b.subscribers[i+1:]...)
+			break
+		}
+	}
+	if sub.done != nil {
+		sub.once.Do(func() {
+			close(sub.done)
+		})
+	}
+}
+
+//publish пишет событие в журнал аудита и ставит его в очередь,
+//вызывающий должен держать s.mu на запись
+func (s *Service) publish(event types.Event) {
+	event.At = s.now()
+	s.recordEvent(event)
+
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+
+	if len(s.events.subscribers) == 0 {
+		return
+	}
+	s.events.seq++
+	event.Seq = s.events.seq
+	s.events.pending = append(s.events.pending, event)
+}
+
+//lock берёт блокировку сервиса на запись для вызова с настройками opts,
+//они действуют до unlock
+func (s *Service) lock(opts []CallOption) {
+	call := callOptions{}
+	for _, opt := range opts {
+		opt(&call)
+	}
+	s.mu.Lock()
+	s.audit.actor = dumpReplacer.Replace(call.actor)
+}
+
+//unlock сохраняет изменения в хранилища, снимает блокировку сервиса
+//и доставляет накопившиеся события и уведомления о бюджетах.
+//Возвращает первую ошибку записи в журнал аудита за время блокировки,
+//а если её нет - ошибку сохранения хранилищ.
+func (s *Service) unlock() error {
+	syncErr := s.syncRepositories()
+	err := s.audit.err
+	if err == nil {
+		err = syncErr
+	}
+	s.audit.err = nil
+	s.audit.actor = ""
+	notifier, alerts := s.takeBudgetAlerts()
+	s.mu.Unlock()
+	s.events.drain()
+	notifyBudgets(notifier, alerts)
+	return err
+}
+
+//unlockInto снимает блокировку как unlock и возвращает её ошибку через *err,
+//если метод не вернул свою
+func (s *Service) unlockInto(err *error) {
+	unlockErr := s.unlock()
+	if *err == nil {
+		*err = unlockErr
+	}
+}
+
+//drain доставляет события из очереди. Если доставкой уже занят другой вызов
+//(в том числе обработчик, вызвавший метод сервиса), события доставит он.
+func (b *eventBus) drain() {
+	b.mu.Lock()
+	if b.draining {
+		b.mu.Unlock()
+		return
+	}
+	b.draining = true
+
+	for len(b.pending) > 0 {
+		event := b.pending[0]
+		b.pending = b.pending[1:]
+		subscribers := b.subscribers
+		b.mu.Unlock()
+
+		for _, sub := range subscribers {
+			sub.deliver(event)
+		}
+
+		b.mu.Lock()
+	}
+	b.pending = nil
+	b.draining = false
+	b.mu.Unlock()
+}
+
+//deliver передаёт событие подписчику, если оно подходит под фильтр
+func (sub *subscriber) deliver(event types.Event) {
+	if !sub.filter.match(event) {
+		return
+	}
+	if sub.events == nil {
+		sub.handler(event)
+		return
+	}
+	select {
+	case sub.events <- event:
+	case <-sub.done:
+	}
+}
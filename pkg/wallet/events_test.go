@@ -0,0 +1,182 @@
+package wallet
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_Subscribe(t *testing.T) {
+	s := newTestService()
+	events := []types.Event{}
+	unsubscribe := s.Subscribe(EventFilter{}, func(event types.Event) {
+		events = append(events, event)
+	})
+
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.EventType{
+		types.EventAccountRegistered,
+		types.EventDeposited,
+		types.EventPaymentCreated,
+		types.EventPaymentRejected,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Subscribe(): events = %v, want types %v", events, want)
+	}
+	for i, event := range events {
+		if event.Type != want[i] || event.AccountID != account.ID || event.Seq != uint64(i+1) {
+			t.Errorf("Subscribe(): event = %v, want type %v", event, want[i])
+		}
+	}
+	if events[2].EntityID != payment.ID || events[2].Amount != 10_00 || !events[2].At.Equal(s.clock.Now()) {
+		t.Errorf("Subscribe(): wrong payment event = %v", events[2])
+	}
+
+	unsubscribe()
+	err = s.Deposit(account.ID, 1_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(want) {
+		t.Errorf("Subscribe(): events after unsubscribe = %v", events[len(want):])
+	}
+}
+
+func TestService_Subscribe_filter(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.addAccountWithBalance("+992000000002", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byAccount := []types.Event{}
+	s.Subscribe(EventFilter{AccountID: second.ID}, func(event types.Event) {
+		byAccount = append(byAccount, event)
+	})
+	byType := []types.Event{}
+	s.Subscribe(EventFilter{Types: []types.EventType{types.EventPaymentCreated}}, func(event types.Event) {
+		byType = append(byType, event)
+	})
+
+	if _, err := s.Pay(first.ID, 1_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := s.Transfer(first.ID, second.ID, 5_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pay(second.ID, 2_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(byAccount) != 2 || byAccount[0].EntityID != transfer.ID || byAccount[0].CounterAccountID != second.ID {
+		t.Errorf("Subscribe(): wrong account events = %v", byAccount)
+	}
+	if len(byType) != 2 || byType[0].AccountID != first.ID || byType[1].AccountID != second.ID {
+		t.Errorf("Subscribe(): wrong type events = %v", byType)
+	}
+}
+
+func TestService_Subscribe_handlerCallsService(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []types.EventType{}
+	s.Subscribe(EventFilter{}, func(event types.Event) {
+		events = append(events, event.Type)
+		if event.Type == types.EventPaymentCreated {
+			if err := s.Complete(event.EntityID); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	payment, err := s.Pay(account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment = s.payment(t, payment.ID)
+	if payment.Status != types.PaymentStatusOk {
+		t.Errorf("Subscribe(): handler must complete payment, status = %v", payment.Status)
+	}
+	if len(events) != 2 || events[0] != types.EventPaymentCreated || events[1] != types.EventPaymentCompleted {
+		t.Errorf("Subscribe(): wrong events order = %v", events)
+	}
+}
+
+func TestService_SubscribeAsync_orderPerAccount(t *testing.T) {
+	s := newTestService()
+	accounts := make([]*types.Account, 4)
+	for i := range accounts {
+		account, err := s.addAccountWithBalance(types.Phone("+99200000000"+strconv.Itoa(i+1)), 1_000_00)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts[i] = account
+	}
+
+	const payments = 50
+	mu := sync.Mutex{}
+	amounts := make(map[int64][]types.Money)
+	wg := sync.WaitGroup{}
+	wg.Add(len(accounts) * payments)
+	unsubscribe := s.SubscribeAsync(EventFilter{Types: []types.EventType{types.EventPaymentCreated}}, 4, func(event types.Event) {
+		mu.Lock()
+		amounts[event.AccountID] = append(amounts[event.AccountID], event.Amount)
+		mu.Unlock()
+		wg.Done()
+	})
+	defer unsubscribe()
+
+	for _, account := range accounts {
+		go func(accountID int64) {
+			for i := 1; i <= payments; i++ {
+				if _, err := s.Pay(accountID, types.Money(i), "auto"); err != nil {
+					t.Error(err)
+				}
+			}
+		}(account.ID)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SubscribeAsync(): events were not delivered")
+	}
+
+	for _, account := range accounts {
+		got := amounts[account.ID]
+		for i, amount := range got {
+			if amount != types.Money(i+1) {
+				t.Fatalf("SubscribeAsync(): account %v events out of order = %v", account.ID, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrFavoriteNameExists = errors.New("favorite with this name already exists")
+
+//AccountFavorites возвращает избранные аккаунта, чаще используемые первыми
+func (s *Service) AccountFavorites(accountID int64) ([]types.Favorite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	favorites := []types.Favorite{}
+	for _, favorite := range s.repositories().Favorites.ByAccount(accountID) {
+		favorites = append(favorites, *favorite)
+	}
+	sort.SliceStable(favorites, func(i, j int) bool {
+		return favorites[i].Uses > favorites[j].Uses
+	})
+	return favorites, nil
+}
+
+//RenameFavorite меняет название избранного
+func (s *Service) RenameFavorite(favoriteID string, name string, opts ...CallOption) (_ *types.Favorite, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	favorite, err := s.getFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	name = dumpReplacer.Replace(name)
+	err = s.checkFavoriteName(favorite.AccountID, name, favorite.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	favorite.Name = name
+	favorite.Updated = s.now()
+	s.dirty.favorites = true
+	s.publishFavoriteUpdated(favorite)
+	return copyFavorite(favorite, nil)
+}
+
+//UpdateFavoriteAmount меняет сумму избранного
+func (s *Service) UpdateFavoriteAmount(favoriteID string, amount types.Money, opts ...CallOption) (_ *types.Favorite, err error) {
+	if amount <= 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, ID: favoriteID, Amount: amount}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	favorite, err := s.getFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	favorite.Amount = amount
+	favorite.Updated = s.now()
+	s.dirty.favorites = true
+	s.publishFavoriteUpdated(favorite)
+	return copyFavorite(favorite, nil)
+}
+
+//DeleteFavorite удаляет избранное. Регулярные платежи по нему отменяются,
+//а удаление запоминается, чтобы Import не восстановил избранное из старого dump.
+func (s *Service) DeleteFavorite(favoriteID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	favorite, err := s.getFavoriteByID(favoriteID)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	s.removeFavorite(favorite)
+	for _, schedule := range s.scheduler.schedules {
+		if schedule.FavoriteID == favorite.ID && schedule.Status != types.ScheduleStatusCancelled {
+			schedule.Status = types.ScheduleStatusCancelled
+			schedule.Updated = now
+			s.record("SCHEDULE_CANCELLED", "schedule:"+schedule.ID, *schedule)
+		}
+	}
+
+	if s.deletedFavorites == nil {
+		s.deletedFavorites = make(map[string]time.Time)
+	}
+	s.deletedFavorites[favorite.ID] = now
+	s.publish(types.Event{
+		Type:      types.EventFavoriteDeleted,
+		AccountID: favorite.AccountID,
+		EntityID:  favorite.ID,
+	})
+	return nil
+}
+
+func (s *Service) publishFavoriteUpdated(favorite *types.Favorite) {
+	s.publish(types.Event{
+		Type:      types.EventFavoriteUpdated,
+		AccountID: favorite.AccountID,
+		EntityID:  favorite.ID,
+		Amount:    favorite.Amount,
+	})
+}
+
+//PayFromFavoriteWith совершает платёж по избранному, заменяя сумму, категорию
+//и примечание непустыми значениями из overrides. Платёж связывается с избранным.
+func (s *Service) PayFromFavoriteWith(favoriteID string, overrides types.FavoriteOverrides, opts ...CallOption) (_ *types.Payment, err error) {
+	if overrides.Amount < 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, ID: favoriteID, Amount: overrides.Amount}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	return copyPayment(s.payFromFavoriteWith(favoriteID, overrides))
+}
+
+func (s *Service) payFromFavoriteWith(favoriteID string, overrides types.FavoriteOverrides) (*types.Payment, error) {
+	favorite, err := s.getFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := favorite.Amount
+	if overrides.Amount != 0 {
+		amount = overrides.Amount
+	}
+	category := favorite.Category
+	if overrides.Category != "" {
+		category = overrides.Category
+	}
+
+	payment, err := s.payWith(&types.Payment{
+		AccountID:  favorite.AccountID,
+		Amount:     amount,
+		Category:   category,
+		FavoriteID: favorite.ID,
+		Note:       dumpReplacer.Replace(overrides.Note),
+	})
+	if err != nil {
+		return nil, err
+	}
+	favorite.Uses++
+	s.dirty.favorites = true
+	return payment, nil
+}
+
+//FavoritePaymentsHistory возвращает платежи, совершённые по избранному.
+//Платежи удалённого избранного тоже возвращаются.
+func (s *Service) FavoritePaymentsHistory(favoriteID string) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	linked := s.repositories().Payments.ByFavorite(favoriteID)
+	if len(linked) == 0 {
+		_, err := s.getFavoriteByID(favoriteID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	payments := []types.Payment{}
+	for _, payment := range linked {
+		payments = append(payments, *payment)
+	}
+	return payments, nil
+}
+
+//checkFavoriteName проверяет, что у аккаунта нет другого избранного с таким названием.
+//Названия сравниваются без учёта регистра и пробелов по краям.
+func (s *Service) checkFavoriteName(accountID int64, name string, exceptID string) error {
+	name = strings.TrimSpace(name)
+	for _, favorite := range s.repositories().Favorites.ByAccount(accountID) {
+		if favorite.ID != exceptID && strings.EqualFold(strings.TrimSpace(favorite.Name), name) {
+			return &Error{Err: ErrFavoriteNameExists, AccountID: accountID, ID: favorite.ID}
+		}
+	}
+	return nil
+}
+
+//exportDeletedFavorites записывает удалённые избранные в favorites_deleted.dump
+func (s *Service) exportDeletedFavorites(dir string) error {
+	if len(s.deletedFavorites) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(s.deletedFavorites))
+	for id := range s.deletedFavorites {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	delData := make([]byte, 0)
+
+	for _, id := range ids {
+		str := id + (";") +
+			formatTime(s.deletedFavorites[id]) + ("\n")
+
+		delData = append(delData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/favorites_deleted.dump", delData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importDeletedFavorites читает favorites_deleted.dump и удаляет перечисленные избранные
+func (s *Service) importDeletedFavorites(dir string) error {
+	path := dir + "/favorites_deleted.dump"
+	delFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, delOperation := range strings.Split(string(delFile), "\n") {
+		if len(delOperation) == 0 {
+			break
+		}
+		delStr, err := dumpFields(path, i+1, delOperation, 2)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := delStr[0]
+		deleted, err := parseTime(delStr[1])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		favorite, _ := s.getFavoriteByID(id)
+		if favorite != nil {
+			s.removeFavorite(favorite)
+		}
+		if s.deletedFavorites == nil {
+			s.deletedFavorites = make(map[string]time.Time)
+		}
+		s.deletedFavorites[id] = deleted
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_AccountFavorites_orderedByUses(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rare, err := s.FavoritePayment(payments[0].ID, "rare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	often, err := s.FavoritePayment(payments[1].ID, "often")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.PayFromFavorite(often.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	favorites, err := s.AccountFavorites(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favorites) != 2 || favorites[0].ID != often.ID || favorites[1].ID != rare.ID {
+		t.Errorf("AccountFavorites(): wrong order = %v", favorites)
+	}
+	if favorites[0].Uses != 2 {
+		t.Errorf("AccountFavorites(): wrong uses = %v", favorites[0].Uses)
+	}
+
+	_, err = s.AccountFavorites(account.ID + 1)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("AccountFavorites(): must return ErrAccountNotFound, returned = %v", err)
+	}
+}
+
+func TestService_FavoritePayment_duplicateName(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.FavoritePayment(payments[0].ID, "Phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.FavoritePayment(payments[1].ID, " phone ")
+	if !errors.Is(err, ErrFavoriteNameExists) {
+		t.Errorf("FavoritePayment(): must return ErrFavoriteNameExists, returned = %v", err)
+	}
+}
+
+func TestService_RenameFavorite(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.FavoritePayment(payments[0].ID, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.FavoritePayment(payments[1].ID, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.RenameFavorite(second.ID, "FIRST")
+	if !errors.Is(err, ErrFavoriteNameExists) {
+		t.Errorf("RenameFavorite(): must return ErrFavoriteNameExists, returned = %v", err)
+	}
+
+	s.clock.Add(time.Hour)
+	renamed, err := s.RenameFavorite(first.ID, "First;renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "First renamed" || !renamed.Updated.Equal(s.clock.Now()) {
+		t.Errorf("RenameFavorite(): wrong favorite = %v", renamed)
+	}
+
+	_, err = s.RenameFavorite("unknown", "x")
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("RenameFavorite(): must return ErrFavoriteNotFound, returned = %v", err)
+	}
+}
+
+func TestService_UpdateFavoriteAmount(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.UpdateFavoriteAmount(favorite.ID, 0)
+	if !errors.Is(err, ErrAmmountMustBePositive) {
+		t.Errorf("UpdateFavoriteAmount(): must return ErrAmmountMustBePositive, returned = %v", err)
+	}
+
+	_, err = s.UpdateFavoriteAmount(favorite.ID, 7_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance := account.Balance
+	payment, err := s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Amount != 7_00 || s.account(t, account.ID).Balance != balance-7_00 {
+		t.Errorf("PayFromFavorite(): wrong payment after update = %v", payment)
+	}
+}
+
+func TestService_DeleteFavorite(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := s.ScheduleFavorite(favorite.ID, types.ScheduleDaily, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.DeleteFavorite(favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GetFavoriteByID(favorite.ID)
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("GetFavoriteByID(): must return ErrFavoriteNotFound, returned = %v", err)
+	}
+	favorites, err := s.AccountFavorites(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favorites) != 0 {
+		t.Errorf("AccountFavorites(): deleted favorite returned = %v", favorites)
+	}
+	schedule, err = s.FindScheduleByID(schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.Status != types.ScheduleStatusCancelled {
+		t.Errorf("DeleteFavorite(): schedule must be cancelled, status = %v", schedule.Status)
+	}
+
+	err = s.DeleteFavorite(favorite.ID)
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("DeleteFavorite(): must return ErrFavoriteNotFound, returned = %v", err)
+	}
+
+	_, err = s.FavoritePayment(payments[1].ID, "phone")
+	if err != nil {
+		t.Errorf("FavoritePayment(): name of deleted favorite must be free, returned = %v", err)
+	}
+}
+
+func TestService_Import_deletedFavorites(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := s.FavoritePayment(payments[0].ID, "kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.FavoritePayment(payments[1].ID, "deleted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PayFromFavorite(kept.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := newTestService()
+	err = stale.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.DeleteFavorite(deleted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stale.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stale.GetFavoriteByID(deleted.ID)
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("Import(): deleted favorite must be removed, returned = %v", err)
+	}
+	favorite, err := stale.GetFavoriteByID(kept.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if favorite.Uses != 1 {
+		t.Errorf("Import(): wrong favorite uses = %v", favorite.Uses)
+	}
+}
+
+func TestService_PayFromFavoriteWith_eventAndAudit(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "electricity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := s.EnableAudit(path); err != nil {
+		t.Fatal(err)
+	}
+	events := []types.Event{}
+	s.Subscribe(EventFilter{Types: []types.EventType{types.EventPaymentCreated}}, func(event types.Event) {
+		events = append(events, event)
+	})
+
+	payment, err := s.PayFromFavoriteWith(favorite.ID, types.FavoriteOverrides{Amount: 23_45, Note: "march"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EntityID != payment.ID || events[0].AccountID != favorite.AccountID || events[0].Amount != 23_45 {
+		t.Errorf("PayFromFavoriteWith(): wrong events = %v", events)
+	}
+
+	records, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created *types.AuditRecord
+	for i := range records {
+		if records[i].Action == string(types.EventPaymentCreated) && records[i].Entity == "payment:"+payment.ID {
+			created = &records[i]
+		}
+	}
+	if created == nil {
+		t.Fatalf("VerifyAuditLog(): no %v record, records = %v", types.EventPaymentCreated, records)
+	}
+	got := types.Payment{}
+	if err := json.Unmarshal([]byte(created.After), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.FavoriteID != favorite.ID || got.Note != "march" || got.Amount != 23_45 {
+		t.Errorf("VerifyAuditLog(): favorite payment state = %v", got)
+	}
+}
+
+func TestService_PayFromFavoriteWith_overrides(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "electricity")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance := account.Balance
+	payment, err := s.PayFromFavoriteWith(favorite.ID, types.FavoriteOverrides{
+		Amount:   23_45,
+		Category: "utilities",
+		Note:     "march;2026",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Amount != 23_45 || payment.Category != "utilities" || payment.Note != "march 2026" {
+		t.Errorf("PayFromFavoriteWith(): overrides not applied = %v", payment)
+	}
+	if payment.FavoriteID != favorite.ID || s.account(t, account.ID).Balance != balance-23_45 {
+		t.Errorf("PayFromFavoriteWith(): wrong payment = %v", payment)
+	}
+
+	plain, err := s.PayFromFavoriteWith(favorite.ID, types.FavoriteOverrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.Amount != favorite.Amount || plain.Category != favorite.Category || plain.FavoriteID != favorite.ID {
+		t.Errorf("PayFromFavoriteWith(): favorite values must be used = %v", plain)
+	}
+
+	_, err = s.PayFromFavoriteWith(favorite.ID, types.FavoriteOverrides{Amount: -1})
+	if !errors.Is(err, ErrAmmountMustBePositive) {
+		t.Errorf("PayFromFavoriteWith(): must return ErrAmmountMustBePositive, returned = %v", err)
+	}
+	_, err = s.PayFromFavoriteWith("unknown", types.FavoriteOverrides{})
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("PayFromFavoriteWith(): must return ErrFavoriteNotFound, returned = %v", err)
+	}
+}
+
+func TestService_FavoritePaymentsHistory(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := s.FavoritePaymentsHistory(favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("FavoritePaymentsHistory(): must be empty = %v", history)
+	}
+
+	first, err := s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.PayFromFavoriteWith(favorite.ID, types.FavoriteOverrides{Note: "bonus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Repeat(first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.DeleteFavorite(favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, svc := range []*testService{s, imported} {
+		history, err := svc.FavoritePaymentsHistory(favorite.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2 || history[0].ID != first.ID || history[1].ID != second.ID || history[1].Note != "bonus" {
+			t.Errorf("FavoritePaymentsHistory(): wrong payments = %v", history)
+		}
+	}
+
+	_, err = s.FavoritePaymentsHistory("unknown")
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("FavoritePaymentsHistory(): must return ErrFavoriteNotFound, returned = %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrInvalidFeeRule = errors.New("invalid fee rule")
+var ErrFeeNotFound = errors.New("fee not found")
+
+//fees правила и списанные комиссии
+type fees struct {
+	rules     []types.FeeRule
+	fees      []*types.Fee
+	byPayment map[string]*types.Fee
+}
+
+//SetFeeRules заменяет правила расчёта комиссий. Для платежа применяется
+//первое подходящее правило в порядке списка, если подходящего нет - комиссии нет.
+//Категория правила может быть псевдонимом и подходит и для дочерних категорий.
+func (s *Service) SetFeeRules(rules []types.FeeRule, opts ...CallOption) (err error) {
+	for i, rule := range rules {
+		if rule.MinAmount < 0 || rule.MaxAmount < 0 || rule.Fixed < 0 || rule.Percent < 0 ||
+			rule.MinFee < 0 || rule.MaxFee < 0 {
+			return ruleError(ErrInvalidFeeRule, "fee_rules", i, "negative value")
+		}
+		if rule.MaxAmount > 0 && rule.MinAmount > rule.MaxAmount {
+			return ruleError(ErrInvalidFeeRule, "fee_rules", i, "min amount greater than max amount")
+		}
+		if rule.MaxFee > 0 && rule.MinFee > rule.MaxFee {
+			return ruleError(ErrInvalidFeeRule, "fee_rules", i, "min fee greater than max fee")
+		}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	resolved := make([]types.FeeRule, len(rules))
+	copy(resolved, rules)
+	for i := range resolved {
+		if resolved[i].Category == "" {
+			continue
+		}
+		category, err := s.resolveCategory(resolved[i].Category)
+		if err != nil {
+			return err
+		}
+		resolved[i].Category = category
+	}
+	s.fees.rules = resolved
+	s.record("SET_FEE_RULES", "fee_rules", s.fees.rules)
+	return nil
+}
+
+//SetAccountTier задаёт тариф счёта
+func (s *Service) SetAccountTier(accountID int64, tier types.AccountTier, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	account.Tier = types.AccountTier(dumpReplacer.Replace(string(tier)))
+	account.Updated = s.now()
+	s.dirty.accounts = true
+	s.record("SET_ACCOUNT_TIER", auditAccount(account.ID), *account)
+	return nil
+}
+
+//CalculateFee возвращает комиссию, которая будет списана за платёж
+func (s *Service) CalculateFee(accountID int64, amount types.Money, category types.PaymentCategory) (types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+	category, err = s.resolveCategory(category)
+	if err != nil {
+		return 0, err
+	}
+	return s.calculateFee(account, amount, category), nil
+}
+
+//FindFeeByPaymentID возвращает комиссию, списанную за платёж
+func (s *Service) FindFeeByPaymentID(paymentID string) (*types.Fee, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fee, ok := s.fees.byPayment[paymentID]
+	if !ok {
+		return nil, &Error{Err: ErrFeeNotFound, ID: paymentID}
+	}
+	return copyFee(fee, nil)
+}
+
+//FeeRevenue возвращает доход от комиссий по категориям за вычетом возвратов
+func (s *Service) FeeRevenue() map[types.PaymentCategory]types.Money {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revenue := make(map[types.PaymentCategory]types.Money)
+	for _, fee := range s.fees.fees {
+		revenue[fee.Category] += fee.Amount - fee.Refunded
+	}
+	return revenue
+}
+
+//calculateFee считает комиссию по первому подходящему правилу, округляя проценты вверх
+func (s *Service) calculateFee(account *types.Account, amount types.Money, category types.PaymentCategory) types.Money {
+	for _, rule := range s.fees.rules {
+		if !s.categoryMatches(rule.Category, category) {
+			continue
+		}
+		if rule.Tier != "" && rule.Tier != account.Tier {
+			continue
+		}
+		if amount < rule.MinAmount || (rule.MaxAmount > 0 && amount > rule.MaxAmount) {
+			continue
+		}
+
+		fee := rule.Fixed + types.Money((int64(amount)*rule.Percent+9_999)/10_000)
+		if fee < rule.MinFee {
+			fee = rule.MinFee
+		}
+		if rule.MaxFee > 0 && fee > rule.MaxFee {
+			fee = rule.MaxFee
+		}
+		return fee
+	}
+	return 0
+}
+
+//chargeFee списывает комиссию за платёж в доходы от комиссий
+func (s *Service) chargeFee(payment *types.Payment, amount types.Money) {
+	if amount <= 0 {
+		return
+	}
+
+	fee := &types.Fee{
+		ID:        uuid.New().String(),
+		PaymentID: payment.ID,
+		AccountID: payment.AccountID,
+		Category:  payment.Category,
+		Amount:    amount,
+		Created:   payment.Created,
+	}
+	s.insertFee(fee)
+	s.post(fee.ID, payment.AccountID, LedgerFeesAccountID, amount)
+}
+
+//refundFee возвращает часть комиссии так, чтобы всего было возвращено
+//столько же процентов комиссии, сколько refunded составляет от суммы платежа.
+//Доли округляются вниз, при полном возврате возвращается остаток.
+func (s *Service) refundFee(payment *types.Payment, refunded types.Money) {
+	fee, ok := s.fees.byPayment[payment.ID]
+	if !ok {
+		return
+	}
+
+	total := fee.Amount
+	if refunded < payment.Amount {
+		total = types.Money(int64(fee.Amount) * int64(refunded) / int64(payment.Amount))
+	}
+	amount := total - fee.Refunded
+	if amount <= 0 {
+		return
+	}
+
+	fee.Refunded += amount
+	s.post(fee.ID, LedgerFeesAccountID, fee.AccountID, amount)
+}
+
+//insertFee добавляет комиссию в хранилище
+func (s *Service) insertFee(fee *types.Fee) {
+	if s.fees.byPayment == nil {
+		s.fees.byPayment = make(map[string]*types.Fee)
+	}
+	s.fees.fees = append(s.fees.fees, fee)
+	s.fees.byPayment[fee.PaymentID] = fee
+}
+
+//exportFees записывает комиссии в fees.dump
+func (s *Service) exportFees(dir string) error {
+	if len(s.fees.fees) == 0 {
+		return nil
+	}
+
+	feeData := make([]byte, 0)
+
+	for _, fee := range s.fees.fees {
+		str := fee.ID + (";") +
+			fee.PaymentID + (";") +
+			strconv.FormatInt(fee.AccountID, 10) + (";") +
+			string(fee.Category) + (";") +
+			strconv.FormatInt(int64(fee.Amount), 10) + (";") +
+			strconv.FormatInt(int64(fee.Refunded), 10) + (";") +
+			formatTime(fee.Created) + ("\n")
+
+		feeData = append(feeData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/fees.dump", feeData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importFees читает комиссии из fees.dump
+func (s *Service) importFees(dir string) error {
+	path := dir + "/fees.dump"
+	feeFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, feeOperation := range strings.Split(string(feeFile), "\n") {
+		if len(feeOperation) == 0 {
+			break
+		}
+		feeStr, err := dumpFields(path, i+1, feeOperation, 7)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := feeStr[0]
+		paymentID := feeStr[1]
+		accountID, err := strconv.ParseInt(feeStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		category := types.PaymentCategory(feeStr[3])
+		amount, err := strconv.ParseInt(feeStr[4], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		refunded, err := strconv.ParseInt(feeStr[5], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		created, err := parseTime(feeStr[6])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		feeFind, ok := s.fees.byPayment[paymentID]
+		if ok {
+			feeFind.Amount = types.Money(amount)
+			feeFind.Refunded = types.Money(refunded)
+			continue
+		}
+		s.insertFee(&types.Fee{
+			ID:        id,
+			PaymentID: paymentID,
+			AccountID: accountID,
+			Category:  category,
+			Amount:    types.Money(amount),
+			Refunded:  types.Money(refunded),
+			Created:   created,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var testFeeRules = []types.FeeRule{
+	{Category: "transfer", Tier: "premium"},
+	{Category: "transfer", MaxAmount: 100_00, Fixed: 1_00},
+	{Category: "transfer", Fixed: 50, Percent: 150, MaxFee: 5_00},
+	{MinAmount: 1_000_00, Percent: 10, MinFee: 20},
+}
+
+func TestService_CalculateFee(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetFeeRules(testFeeRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		amount   types.Money
+		category types.PaymentCategory
+		fee      types.Money
+	}{
+		{amount: 50_00, category: "transfer", fee: 1_00},
+		{amount: 200_00, category: "transfer", fee: 3_50},
+		{amount: 1_000_00, category: "transfer", fee: 5_00},
+		{amount: 1_000_00, category: "auto", fee: 1_00},
+		{amount: 1_000_01, category: "auto", fee: 1_01},
+		{amount: 999_99, category: "auto", fee: 0},
+	}
+	for _, tt := range tests {
+		fee, err := s.CalculateFee(account.ID, tt.amount, tt.category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fee != tt.fee {
+			t.Errorf("CalculateFee(%v, %v): fee = %v, want %v", tt.amount, tt.category, fee, tt.fee)
+		}
+	}
+
+	err = s.SetAccountTier(account.ID, "premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, err := s.CalculateFee(account.ID, 50_00, "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee != 0 {
+		t.Errorf("CalculateFee(): premium tier must be free, fee = %v", fee)
+	}
+
+	err = s.SetFeeRules([]types.FeeRule{{MinFee: 10, MaxFee: 5}})
+	if !errors.Is(err, ErrInvalidFeeRule) {
+		t.Errorf("SetFeeRules(): must return ErrInvalidFeeRule, returned = %v", err)
+	}
+}
+
+func TestService_Pay_chargesFee(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetFeeRules(testFeeRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, 99_50, "transfer")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay(): fee must be covered by balance, returned = %v", err)
+	}
+
+	payment, err := s.Pay(account.ID, 50_00, "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 49_00 {
+		t.Errorf("Pay(): wrong balance = %v", account.Balance)
+	}
+	fee, err := s.FindFeeByPaymentID(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.Amount != 1_00 || fee.Category != "transfer" || fee.AccountID != account.ID {
+		t.Errorf("FindFeeByPaymentID(): wrong fee = %v", fee)
+	}
+	entries, err := s.AccountEntries(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := entries[len(entries)-1]
+	if last.OperationID != fee.ID || last.CounterAccountID != LedgerFeesAccountID || last.Amount != -1_00 {
+		t.Errorf("Pay(): fee must be posted to fees account, entry = %v", last)
+	}
+
+	free, err := s.Pay(account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.FindFeeByPaymentID(free.ID)
+	if !errors.Is(err, ErrFeeNotFound) {
+		t.Errorf("FindFeeByPaymentID(): must return ErrFeeNotFound, returned = %v", err)
+	}
+}
+
+func TestService_fees_refundedProportionally(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetFeeRules(testFeeRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refunded, err := s.Pay(account.ID, 200_00, "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := s.Pay(account.ID, 300_00, "transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 1_000_00-200_00-3_50-300_00-5_00 {
+		t.Fatalf("Pay(): wrong balance = %v", account.Balance)
+	}
+
+	err = s.Complete(refunded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Refund(refunded.ID, 50_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, err := s.FindFeeByPaymentID(refunded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.Refunded != 87 {
+		t.Errorf("Refund(): quarter of fee must be refunded, refunded = %v", fee.Refunded)
+	}
+	_, err = s.Refund(refunded.ID, 150_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, err = s.FindFeeByPaymentID(refunded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.Refunded != 3_50 {
+		t.Errorf("Refund(): whole fee must be refunded, refunded = %v", fee.Refunded)
+	}
+
+	err = s.Reject(rejected.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 1_000_00 {
+		t.Errorf("Reject(): payment and fee must be returned, balance = %v", account.Balance)
+	}
+	revenue := s.FeeRevenue()
+	if !reflect.DeepEqual(revenue, map[types.PaymentCategory]types.Money{"transfer": 0}) {
+		t.Errorf("FeeRevenue(): wrong revenue = %v", revenue)
+	}
+	err = s.VerifyLedger()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_FeeRevenue(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetFeeRules(testFeeRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pay := range []struct {
+		amount   types.Money
+		category types.PaymentCategory
+	}{
+		{50_00, "transfer"},
+		{200_00, "transfer"},
+		{2_000_00, "auto"},
+		{10_00, "auto"},
+	} {
+		if _, err := s.Pay(account.ID, pay.amount, pay.category); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[types.PaymentCategory]types.Money{"transfer": 4_50, "auto": 2_00}
+	revenue := s.FeeRevenue()
+	if !reflect.DeepEqual(revenue, want) {
+		t.Errorf("FeeRevenue(): revenue = %v, want %v", revenue, want)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revenue = imported.FeeRevenue()
+	if !reflect.DeepEqual(revenue, want) {
+		t.Errorf("Import(): revenue = %v, want %v", revenue, want)
+	}
+	importedAccount, err := imported.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if importedAccount.Balance != account.Balance {
+		t.Errorf("Import(): wrong balance = %v", importedAccount.Balance)
+	}
+}
@@ -0,0 +1,433 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrHoldNotFound = errors.New("hold not found")
+var ErrHoldNotActive = errors.New("hold is not active")
+var ErrHoldExpired = errors.New("hold expired")
+var ErrCaptureExceedsHold = errors.New("capture amount exceeds hold")
+
+//DefaultHoldTTL сколько по умолчанию живёт блокировка
+const DefaultHoldTTL = 7 * 24 * time.Hour
+
+//holds хранилище блокировок средств
+type holds struct {
+	ttl       time.Duration
+	holds     []*types.Hold
+	byID      map[string]*types.Hold
+	byAccount map[int64][]*types.Hold
+}
+
+//SetHoldTTL задаёт, через сколько активная блокировка снимается автоматически.
+//Уже созданные блокировки сохраняют прежний срок.
+func (s *Service) SetHoldTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	s.holds.ttl = ttl
+}
+
+func (s *Service) holdTTL() time.Duration {
+	if s.holds.ttl <= 0 {
+		return DefaultHoldTTL
+	}
+	return s.holds.ttl
+}
+
+//Authorize блокирует amount вместе с комиссией за него и резервирует amount
+//в лимитах расходов. Доступный баланс уменьшается сразу, а текущий баланс
+//и журнал меняются только при Capture.
+func (s *Service) Authorize(accountID int64, amount types.Money, category types.PaymentCategory, opts ...CallOption) (_ *types.Hold, err error) {
+	if amount <= 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, AccountID: accountID, Amount: amount}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	category, err = s.resolveCategory(category)
+	if err != nil {
+		return nil, err
+	}
+
+	fee := s.calculateFee(account, amount, category)
+	if !s.canDebit(account, amount+fee) {
+		return nil, &Error{Err: ErrNotEnoughBalance, AccountID: account.ID, Amount: amount + fee}
+	}
+
+	err = s.checkLimits(account.ID, amount, category)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	hold := &types.Hold{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Amount:    amount,
+		Fee:       fee,
+		Category:  category,
+		Status:    types.HoldStatusActive,
+		Expires:   now.Add(s.holdTTL()),
+		Created:   now,
+		Updated:   now,
+	}
+	s.insertHold(hold)
+	account.Held += hold.Reserved()
+	account.Updated = now
+	s.dirty.accounts = true
+	s.recordAccount("HELD_CHANGED", account.ID)
+	s.publish(types.Event{
+		Type:      types.EventHoldAuthorized,
+		AccountID: account.ID,
+		EntityID:  hold.ID,
+		Amount:    amount,
+	})
+
+	return copyHold(hold, nil)
+}
+
+//Capture списывает окончательную сумму по блокировке и снимает её.
+//finalAmount не может быть больше заблокированной суммы. Лимиты повторно
+//не проверяются: сумма была зарезервирована в них при Authorize.
+func (s *Service) Capture(holdID string, finalAmount types.Money, opts ...CallOption) (_ *types.Payment, err error) {
+	if finalAmount <= 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, ID: holdID, Amount: finalAmount}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	hold, err := s.findHoldByID(holdID)
+	if err != nil {
+		return nil, err
+	}
+	s.expireAccountHolds(hold.AccountID)
+	if hold.Status == types.HoldStatusExpired {
+		return nil, &Error{Err: ErrHoldExpired, AccountID: hold.AccountID, ID: hold.ID}
+	}
+	if hold.Status != types.HoldStatusActive {
+		return nil, &Error{Err: ErrHoldNotActive, AccountID: hold.AccountID, ID: hold.ID}
+	}
+	if finalAmount > hold.Amount {
+		return nil, &Error{Err: ErrCaptureExceedsHold, AccountID: hold.AccountID, ID: hold.ID, Amount: finalAmount}
+	}
+
+	account, err := s.findAccountByID(hold.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	account.Held -= hold.Reserved()
+	fee := s.calculateFee(account, finalAmount, hold.Category)
+	if !s.canDebit(account, finalAmount+fee) {
+		account.Held += hold.Reserved()
+		return nil, &Error{Err: ErrNotEnoughBalance, AccountID: account.ID, Amount: finalAmount + fee}
+	}
+	payment := s.createPayment(&types.Payment{AccountID: account.ID, Amount: finalAmount, Category: hold.Category}, fee)
+	err = s.completePayment(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Status = types.HoldStatusCaptured
+	hold.PaymentID = payment.ID
+	hold.Updated = payment.Updated
+	s.publish(types.Event{
+		Type:      types.EventHoldCaptured,
+		AccountID: account.ID,
+		EntityID:  hold.ID,
+		Amount:    finalAmount,
+	})
+	return copyPayment(payment, nil)
+}
+
+//Void снимает блокировку без списания. Повторная отмена ничего не делает.
+func (s *Service) Void(holdID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	hold, err := s.findHoldByID(holdID)
+	if err != nil {
+		return err
+	}
+	if hold.Status == types.HoldStatusVoided {
+		return nil
+	}
+	if hold.Status != types.HoldStatusActive {
+		return &Error{Err: ErrHoldNotActive, AccountID: hold.AccountID, ID: hold.ID}
+	}
+
+	return s.releaseHold(hold, types.HoldStatusVoided)
+}
+
+//ExpireHolds снимает все просроченные блокировки и возвращает их количество.
+//Методы чтения уже показывают просроченные блокировки снятыми, а ExpireHolds
+//снимает их в данных сервиса: публикует события и сохраняет изменения в хранилища.
+//Если изменения не удалось сохранить, количество возвращается вместе с ошибкой.
+func (s *Service) ExpireHolds(opts ...CallOption) (_ int, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	expired := 0
+	for accountID := range s.holds.byAccount {
+		expired += s.expireAccountHolds(accountID)
+	}
+	return expired, nil
+}
+
+//FindHoldByID поиск блокировки по ID
+func (s *Service) FindHoldByID(holdID string) (*types.Hold, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.holdView(s.findHoldByID(holdID))
+}
+
+func (s *Service) findHoldByID(holdID string) (*types.Hold, error) {
+	hold, ok := s.holds.byID[holdID]
+	if !ok {
+		return nil, &Error{Err: ErrHoldNotFound, ID: holdID}
+	}
+	return hold, nil
+}
+
+//AccountHolds возвращает все блокировки аккаунта
+func (s *Service) AccountHolds(accountID int64) ([]types.Hold, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	holds := []types.Hold{}
+	for _, hold := range s.holds.byAccount[accountID] {
+		view, _ := s.holdView(hold, nil)
+		holds = append(holds, *view)
+	}
+	return holds, nil
+}
+
+//holdExpired проверяет, что активная блокировка просрочена и её пора снять
+func holdExpired(hold *types.Hold, now time.Time) bool {
+	return hold.Status == types.HoldStatusActive && !now.Before(hold.Expires)
+}
+
+//holdView возвращает копию блокировки, просроченная блокировка показывается снятой.
+//Чтение идёт под RLock и не может снять её в данных сервиса, это делают запись и ExpireHolds.
+func (s *Service) holdView(hold *types.Hold, err error) (*types.Hold, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *hold
+	if holdExpired(hold, s.now()) {
+		result.Status = types.HoldStatusExpired
+	}
+	return &result, nil
+}
+
+//accountView возвращает копию аккаунта, в Held которой не учтены просроченные блокировки, см. holdView
+func (s *Service) accountView(account *types.Account, err error) (*types.Account, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := *account
+	now := s.now()
+	for _, hold := range s.holds.byAccount[account.ID] {
+		if holdExpired(hold, now) {
+			result.Held -= hold.Reserved()
+		}
+	}
+	return &result, nil
+}
+
+//expireAccountHolds снимает просроченные блокировки аккаунта
+func (s *Service) expireAccountHolds(accountID int64) int {
+	now := s.now()
+	expired := 0
+	for _, hold := range s.holds.byAccount[accountID] {
+		if !holdExpired(hold, now) {
+			continue
+		}
+		if s.releaseHold(hold, types.HoldStatusExpired) == nil {
+			expired++
+		}
+	}
+	return expired
+}
+
+//releaseHold снимает активную блокировку, возвращая сумму в доступный баланс
+func (s *Service) releaseHold(hold *types.Hold, status types.HoldStatus) error {
+	account, err := s.findAccountByID(hold.AccountID)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	account.Held -= hold.Reserved()
+	account.Updated = now
+	s.dirty.accounts = true
+	s.recordAccount("HELD_CHANGED", account.ID)
+	hold.Status = status
+	hold.Updated = now
+	s.publish(types.Event{
+		Type:      types.EventHoldReleased,
+		AccountID: account.ID,
+		EntityID:  hold.ID,
+		Amount:    hold.Amount,
+	})
+	return nil
+}
+
+//insertHold добавляет блокировку в хранилище
+func (s *Service) insertHold(hold *types.Hold) {
+	if s.holds.byID == nil {
+		s.holds.byID = make(map[string]*types.Hold)
+		s.holds.byAccount = make(map[int64][]*types.Hold)
+	}
+	s.holds.holds = append(s.holds.holds, hold)
+	s.holds.byID[hold.ID] = hold
+	s.holds.byAccount[hold.AccountID] = append(s.holds.byAccount[hold.AccountID], hold)
+}
+
+//exportHolds записывает блокировки в holds.dump
+func (s *Service) exportHolds(dir string) error {
+	if len(s.holds.holds) == 0 {
+		return nil
+	}
+
+	holdData := make([]byte, 0)
+
+	for _, hold := range s.holds.holds {
+		str := hold.ID + (";") +
+			strconv.FormatInt(hold.AccountID, 10) + (";") +
+			strconv.FormatInt(int64(hold.Amount), 10) + (";") +
+			string(hold.Category) + (";") +
+			string(hold.Status) + (";") +
+			hold.PaymentID + (";") +
+			formatTime(hold.Expires) + (";") +
+			formatTime(hold.Created) + (";") +
+			formatTime(hold.Updated) + (";") +
+			strconv.FormatInt(int64(hold.Fee), 10) + ("\n")
+
+		holdData = append(holdData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/holds.dump", holdData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importHolds читает блокировки из holds.dump и пересчитывает заблокированные суммы
+//на счетах, у которых есть блокировки
+func (s *Service) importHolds(dir string) error {
+	path := dir + "/holds.dump"
+	holdFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, holdOperation := range strings.Split(string(holdFile), "\n") {
+		if len(holdOperation) == 0 {
+			break
+		}
+		holdStr, err := dumpFields(path, i+1, holdOperation, 7)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := holdStr[0]
+		accountID, err := strconv.ParseInt(holdStr[1], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		amount, err := strconv.ParseInt(holdStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		category := types.PaymentCategory(holdStr[3])
+		status := types.HoldStatus(holdStr[4])
+		paymentID := holdStr[5]
+		expires, err := parseTime(holdStr[6])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		created, updated, err := parseTimes(holdStr, 7)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		var fee int64
+		if len(holdStr) > 9 {
+			fee, err = strconv.ParseInt(holdStr[9], 10, 64)
+			if err != nil {
+				log.Print(err)
+				return dumpError(path, i+1, err)
+			}
+		}
+
+		holdFind, _ := s.findHoldByID(id)
+		if holdFind != nil {
+			holdFind.Amount = types.Money(amount)
+			holdFind.Fee = types.Money(fee)
+			holdFind.Category = category
+			holdFind.Status = status
+			holdFind.PaymentID = paymentID
+			holdFind.Expires = expires
+			holdFind.Created = created
+			holdFind.Updated = updated
+			continue
+		}
+		s.insertHold(&types.Hold{
+			ID:        id,
+			AccountID: accountID,
+			Amount:    types.Money(amount),
+			Fee:       types.Money(fee),
+			Category:  category,
+			Status:    status,
+			PaymentID: paymentID,
+			Expires:   expires,
+			Created:   created,
+			Updated:   updated,
+		})
+	}
+
+	for accountID, holds := range s.holds.byAccount {
+		account, err := s.findAccountByID(accountID)
+		if err != nil {
+			continue
+		}
+		account.Held = 0
+		s.dirty.accounts = true
+		for _, hold := range holds {
+			if hold.Status == types.HoldStatusActive {
+				account.Held += hold.Reserved()
+			}
+		}
+	}
+	return nil
+}
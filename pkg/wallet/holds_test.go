@@ -0,0 +1,333 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_Authorize_reducesAvailable(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hold, err := s.Authorize(account.ID, 60_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 100_00 || account.Available() != 40_00 {
+		t.Errorf("Authorize(): wrong balances current = %v, available = %v", account.Balance, account.Available())
+	}
+	ledger, err := s.LedgerBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledger != 100_00 {
+		t.Errorf("Authorize(): hold must not be posted, ledger = %v", ledger)
+	}
+	if hold.Status != types.HoldStatusActive || !hold.Expires.Equal(s.clock.Now().Add(DefaultHoldTTL)) {
+		t.Errorf("Authorize(): wrong hold = %v", hold)
+	}
+
+	_, err = s.Pay(account.ID, 50_00, "auto")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	_, err = s.Authorize(account.ID, 50_00, "fuel")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Authorize(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	_, err = s.Authorize(account.ID, 0, "fuel")
+	if !errors.Is(err, ErrAmmountMustBePositive) {
+		t.Errorf("Authorize(): must return ErrAmmountMustBePositive, returned = %v", err)
+	}
+}
+
+func TestService_Capture(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold, err := s.Authorize(account.ID, 60_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Capture(hold.ID, 61_00)
+	if !errors.Is(err, ErrCaptureExceedsHold) {
+		t.Errorf("Capture(): must return ErrCaptureExceedsHold, returned = %v", err)
+	}
+
+	payment, err := s.Capture(hold.ID, 45_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Amount != 45_00 || payment.Category != "fuel" || payment.Status != types.PaymentStatusOk {
+		t.Errorf("Capture(): wrong payment = %v", payment)
+	}
+	ledger, err := s.LedgerBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 55_00 || account.Held != 0 || ledger != 55_00 {
+		t.Errorf("Capture(): wrong balances current = %v, held = %v", account.Balance, account.Held)
+	}
+	hold, err = s.FindHoldByID(hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hold.Status != types.HoldStatusCaptured || hold.PaymentID != payment.ID {
+		t.Errorf("Capture(): wrong hold = %v", hold)
+	}
+
+	_, err = s.Capture(hold.ID, 1_00)
+	if !errors.Is(err, ErrHoldNotActive) {
+		t.Errorf("Capture(): must return ErrHoldNotActive, returned = %v", err)
+	}
+	err = s.Void(hold.ID)
+	if !errors.Is(err, ErrHoldNotActive) {
+		t.Errorf("Void(): must return ErrHoldNotActive, returned = %v", err)
+	}
+}
+
+func TestService_Void(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold, err := s.Authorize(account.ID, 60_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Void(hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold, err = s.FindHoldByID(hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Available() != 100_00 || hold.Status != types.HoldStatusVoided {
+		t.Errorf("Void(): hold must be released, available = %v, hold = %v", account.Available(), hold)
+	}
+	err = s.Void(hold.ID)
+	if err != nil {
+		t.Errorf("Void(): repeated void must succeed, returned = %v", err)
+	}
+	_, err = s.Capture(hold.ID, 10_00)
+	if !errors.Is(err, ErrHoldNotActive) {
+		t.Errorf("Capture(): must return ErrHoldNotActive, returned = %v", err)
+	}
+	err = s.Void("unknown")
+	if !errors.Is(err, ErrHoldNotFound) {
+		t.Errorf("Void(): must return ErrHoldNotFound, returned = %v", err)
+	}
+}
+
+func TestService_holdExpiry(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetHoldTTL(time.Hour)
+	stale, err := s.Authorize(account.ID, 60_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(30 * time.Minute)
+	fresh, err := s.Authorize(account.ID, 10_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.clock.Add(30 * time.Minute)
+	_, err = s.Capture(stale.ID, 10_00)
+	if !errors.Is(err, ErrHoldExpired) {
+		t.Errorf("Capture(): must return ErrHoldExpired, returned = %v", err)
+	}
+	stale, err = s.FindHoldByID(stale.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if stale.Status != types.HoldStatusExpired || account.Held != 10_00 {
+		t.Errorf("Capture(): stale hold must expire, hold = %v, held = %v", stale, account.Held)
+	}
+
+	s.clock.Add(30 * time.Minute)
+	fresh, err = s.FindHoldByID(fresh.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holds, err := s.AccountHolds(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if fresh.Status != types.HoldStatusExpired || holds[1].Status != types.HoldStatusExpired || account.Available() != 100_00 {
+		t.Errorf("FindHoldByID(): expired hold must be shown released before ExpireHolds, hold = %v, available = %v", fresh, account.Available())
+	}
+	if expired, err := s.ExpireHolds(); err != nil || expired != 1 {
+		t.Errorf("ExpireHolds(): wrong expired count = %v, err = %v", expired, err)
+	}
+	fresh, err = s.FindHoldByID(fresh.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if fresh.Status != types.HoldStatusExpired || account.Available() != 100_00 {
+		t.Errorf("ExpireHolds(): hold must expire, hold = %v, available = %v", fresh, account.Available())
+	}
+}
+
+func TestService_ExpireHolds_syncError(t *testing.T) {
+	accounts := &countingAccounts{MemoryAccountRepository: NewMemoryAccountRepository(
+		&types.Account{ID: 1, Phone: "+992000000001", Balance: 100_00},
+	)}
+	clock := newFakeClock()
+	s := &testService{Service: NewService(Repositories{Accounts: accounts}), clock: clock}
+	s.SetClock(clock)
+	if _, err := s.Authorize(1, 10_00, "fuel"); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts.err = errors.New("disk full")
+	s.clock.Add(DefaultHoldTTL)
+	expired, err := s.ExpireHolds()
+	if !errors.Is(err, ErrRepositorySync) || expired != 1 {
+		t.Errorf("ExpireHolds(): expired = %v, must return ErrRepositorySync, returned = %v", expired, err)
+	}
+}
+
+func TestService_Import_holds(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold, err := s.Authorize(account.ID, 30_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	voided, err := s.Authorize(account.ID, 20_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Void(voided.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importedAccount, err := imported.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if importedAccount.Held != 30_00 || importedAccount.Available() != 70_00 {
+		t.Errorf("Import(): wrong held amount = %v", importedAccount.Held)
+	}
+	holds, err := imported.AccountHolds(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holds) != 2 || holds[0].ID != hold.ID || !holds[0].Expires.Equal(hold.Expires) {
+		t.Errorf("Import(): wrong holds = %v", holds)
+	}
+
+	_, err = imported.Capture(hold.ID, 30_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	importedAccount = imported.account(t, account.ID)
+	if importedAccount.Balance != 70_00 || importedAccount.Held != 0 {
+		t.Errorf("Capture(): wrong balances after import current = %v, held = %v", importedAccount.Balance, importedAccount.Held)
+	}
+}
+
+func TestService_Authorize_reservesLimits(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 2000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetSpendingLimits(account.ID, types.SpendingLimits{Daily: 1000_00})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hold, err := s.Authorize(account.ID, 400_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(account.ID, 700_00, "auto")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Remaining != 600_00 {
+		t.Errorf("Pay(): hold must reserve the daily limit, returned = %v", err)
+	}
+	_, err = s.Pay(account.ID, 400_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Capture(hold.ID, 400_00)
+	if err != nil {
+		t.Errorf("Capture(): reserved amount must not be checked again, returned = %v", err)
+	}
+	_, err = s.Pay(account.ID, 300_00, "auto")
+	if !errors.As(err, &limitErr) || limitErr.Remaining != 200_00 {
+		t.Errorf("Pay(): captured hold must count as spent, returned = %v", err)
+	}
+}
+
+func TestService_Authorize_reservesFee(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetFeeRules([]types.FeeRule{{Fixed: 5_00}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Authorize(account.ID, 96_00, "fuel")
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Authorize(): must reserve the fee, returned = %v", err)
+	}
+	hold, err := s.Authorize(account.ID, 95_00, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hold.Fee != 5_00 || s.account(t, account.ID).Available() != 0 {
+		t.Errorf("Authorize(): wrong hold = %v, available = %v", hold, s.account(t, account.ID).Available())
+	}
+
+	_, err = s.Capture(hold.ID, 95_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 0 || account.Held != 0 {
+		t.Errorf("Capture(): wrong balances current = %v, held = %v", account.Balance, account.Held)
+	}
+}
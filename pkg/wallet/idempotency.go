@@ -0,0 +1,287 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrIdempotencyKeyReused = errors.New("idempotency key already used for another request")
+var ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+
+//DefaultIdempotencyRetention сколько по умолчанию помнится ключ идемпотентности
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+//Операции, для которых запоминаются ключи идемпотентности
+const (
+	operationPay             = "pay"
+	operationDeposit         = "deposit"
+	operationPayFromFavorite = "favorite"
+	operationRepeat          = "repeat"
+)
+
+//idempotencyRecord результат операции, выполненной с ключом
+type idempotencyRecord struct {
+	operation string
+	request   string
+	resultID  string
+	created   time.Time
+}
+
+//idempotency хранилище ключей идемпотентности
+type idempotency struct {
+	retention time.Duration
+	records   map[string]*idempotencyRecord
+	//swept время последней очистки истёкших ключей
+	swept time.Time
+}
+
+//SetIdempotencyRetention задаёт, сколько помнится ключ идемпотентности
+func (s *Service) SetIdempotencyRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	s.idempotency.retention = retention
+}
+
+func (s *Service) idempotencyRetention() time.Duration {
+	if s.idempotency.retention <= 0 {
+		return DefaultIdempotencyRetention
+	}
+	return s.idempotency.retention
+}
+
+//lookupIdempotency ищет результат, сохранённый под ключом key.
+//Возвращает "" если ключ не встречался или истёк.
+func (s *Service) lookupIdempotency(key string, operation string, request string) (string, error) {
+	if strings.ContainsAny(key, ";\n\r") {
+		return "", &Error{Err: ErrInvalidIdempotencyKey, ID: key}
+	}
+
+	record, ok := s.idempotency.records[key]
+	if !ok {
+		return "", nil
+	}
+	if s.now().Sub(record.created) > s.idempotencyRetention() {
+		delete(s.idempotency.records, key)
+		return "", nil
+	}
+	if record.operation != operation || record.request != request {
+		return "", &Error{Err: ErrIdempotencyKeyReused, ID: key}
+	}
+	return record.resultID, nil
+}
+
+//saveIdempotency запоминает результат операции под ключом key
+func (s *Service) saveIdempotency(key string, operation string, request string, resultID string) {
+	if s.idempotency.records == nil {
+		s.idempotency.records = make(map[string]*idempotencyRecord)
+	}
+	s.sweepIdempotency()
+	s.idempotency.records[key] = &idempotencyRecord{
+		operation: operation,
+		request:   request,
+		resultID:  resultID,
+		created:   s.now(),
+	}
+}
+
+//sweepIdempotency удаляет истёкшие ключи, которые больше никто не спрашивал.
+//Полный проход делается не чаще раза за срок хранения ключа.
+func (s *Service) sweepIdempotency() {
+	now := s.now()
+	if now.Sub(s.idempotency.swept) < s.idempotencyRetention() {
+		return
+	}
+	s.idempotency.swept = now
+	for key, record := range s.idempotency.records {
+		if now.Sub(record.created) > s.idempotencyRetention() {
+			delete(s.idempotency.records, key)
+		}
+	}
+}
+
+//idempotencyRequest описание аргументов запроса для сравнения при повторе
+func idempotencyRequest(args ...string) string {
+	return dumpReplacer.Replace(strings.Join(args, "|"))
+}
+
+//PayIdempotent как Pay, но повтор с тем же ключом возвращает исходный платёж
+//вместо нового списания. Пустой ключ отключает проверку.
+func (s *Service) PayIdempotent(key string, accountID int64, amount types.Money, category types.PaymentCategory, opts ...CallOption) (_ *types.Payment, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	if key == "" {
+		return copyPayment(s.pay(accountID, amount, category))
+	}
+
+	request := idempotencyRequest(strconv.FormatInt(accountID, 10), strconv.FormatInt(int64(amount), 10), string(category))
+	paymentID, err := s.lookupIdempotency(key, operationPay, request)
+	if err != nil {
+		return nil, err
+	}
+	if paymentID != "" {
+		return copyPayment(s.findPaymentByID(paymentID))
+	}
+
+	payment, err := s.pay(accountID, amount, category)
+	if err != nil {
+		return nil, err
+	}
+	s.saveIdempotency(key, operationPay, request, payment.ID)
+	return copyPayment(payment, nil)
+}
+
+//DepositIdempotent как Deposit, но повтор с тем же ключом возвращает исходное пополнение
+func (s *Service) DepositIdempotent(key string, accountID int64, amount types.Money, opts ...CallOption) (_ *types.Deposit, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	if key == "" {
+		return copyDeposit(s.deposit(accountID, amount, ""))
+	}
+
+	request := idempotencyRequest(strconv.FormatInt(accountID, 10), strconv.FormatInt(int64(amount), 10))
+	depositID, err := s.lookupIdempotency(key, operationDeposit, request)
+	if err != nil {
+		return nil, err
+	}
+	if depositID != "" {
+		return copyDeposit(s.findDepositByID(depositID))
+	}
+
+	deposit, err := s.deposit(accountID, amount, "")
+	if err != nil {
+		return nil, err
+	}
+	s.saveIdempotency(key, operationDeposit, request, deposit.ID)
+	return copyDeposit(deposit, nil)
+}
+
+//PayFromFavoriteIdempotent как PayFromFavorite, но повтор с тем же ключом возвращает исходный платёж
+func (s *Service) PayFromFavoriteIdempotent(key string, favoriteID string, opts ...CallOption) (_ *types.Payment, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	if key == "" {
+		return copyPayment(s.payFromFavorite(favoriteID))
+	}
+
+	request := idempotencyRequest(favoriteID)
+	paymentID, err := s.lookupIdempotency(key, operationPayFromFavorite, request)
+	if err != nil {
+		return nil, err
+	}
+	if paymentID != "" {
+		return copyPayment(s.findPaymentByID(paymentID))
+	}
+
+	payment, err := s.payFromFavorite(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+	s.saveIdempotency(key, operationPayFromFavorite, request, payment.ID)
+	return copyPayment(payment, nil)
+}
+
+//RepeatIdempotent как Repeat, но повтор с тем же ключом возвращает исходный платёж
+func (s *Service) RepeatIdempotent(key string, paymentID string, opts ...CallOption) (_ *types.Payment, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	if key == "" {
+		return copyPayment(s.repeat(paymentID))
+	}
+
+	request := idempotencyRequest(paymentID)
+	newPaymentID, err := s.lookupIdempotency(key, operationRepeat, request)
+	if err != nil {
+		return nil, err
+	}
+	if newPaymentID != "" {
+		return copyPayment(s.findPaymentByID(newPaymentID))
+	}
+
+	payment, err := s.repeat(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	s.saveIdempotency(key, operationRepeat, request, payment.ID)
+	return copyPayment(payment, nil)
+}
+
+//exportIdempotency записывает действующие ключи в idempotency.dump
+func (s *Service) exportIdempotency(dir string) error {
+	if len(s.idempotency.records) == 0 {
+		return nil
+	}
+
+	keyData := make([]byte, 0)
+
+	for key, record := range s.idempotency.records {
+		if s.now().Sub(record.created) > s.idempotencyRetention() {
+			continue
+		}
+		str := key + (";") +
+			record.operation + (";") +
+			record.request + (";") +
+			record.resultID + (";") +
+			strconv.FormatInt(record.created.UnixNano(), 10) + ("\n")
+
+		keyData = append(keyData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/idempotency.dump", keyData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importIdempotency читает ключи из idempotency.dump, истёкшие ключи пропускаются
+func (s *Service) importIdempotency(dir string) error {
+	path := dir + "/idempotency.dump"
+	keyFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, keyOperation := range strings.Split(string(keyFile), "\n") {
+		if len(keyOperation) == 0 {
+			break
+		}
+		keyStr, err := dumpFields(path, i+1, keyOperation, 5)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		created, err := strconv.ParseInt(keyStr[4], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		record := &idempotencyRecord{
+			operation: keyStr[1],
+			request:   keyStr[2],
+			resultID:  keyStr[3],
+			created:   time.Unix(0, created),
+		}
+		if s.now().Sub(record.created) > s.idempotencyRetention() {
+			continue
+		}
+
+		if s.idempotency.records == nil {
+			s.idempotency.records = make(map[string]*idempotencyRecord)
+		}
+		s.idempotency.records[keyStr[0]] = record
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestService_PayIdempotent(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := s.PayIdempotent("key-1", account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, err := s.PayIdempotent("key-1", account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.ID != payment.ID {
+		t.Errorf("PayIdempotent(): retry must return original payment, returned = %v", retry)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 90_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, 90_00)
+	}
+
+	if _, err := s.PayIdempotent("key-1", account.ID, 20_00, "auto"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("PayIdempotent(): must return ErrIdempotencyKeyReused, returned = %v", err)
+	}
+	if _, err := s.DepositIdempotent("key-1", account.ID, 10_00); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("DepositIdempotent(): must return ErrIdempotencyKeyReused, returned = %v", err)
+	}
+	if _, err := s.PayIdempotent("key;2", account.ID, 10_00, "auto"); !errors.Is(err, ErrInvalidIdempotencyKey) {
+		t.Errorf("PayIdempotent(): must return ErrInvalidIdempotencyKey, returned = %v", err)
+	}
+
+	first, err := s.PayIdempotent("", account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.PayIdempotent("", account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID {
+		t.Error("PayIdempotent(): empty key must create new payments")
+	}
+}
+
+func TestService_PayIdempotent_failedNotRemembered(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 5_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.PayIdempotent("key-1", account.ID, 10_00, "auto"); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Fatalf("PayIdempotent(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	if err := s.Deposit(account.ID, 5_00); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PayIdempotent("key-1", account.ID, 10_00, "auto"); err != nil {
+		t.Errorf("PayIdempotent(): retry after failure must succeed, returned = %v", err)
+	}
+}
+
+func TestService_DepositIdempotent(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.DepositIdempotent("key-1", account.ID, 10_00); err != nil {
+			t.Fatal(err)
+		}
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 10_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, 10_00)
+	}
+}
+
+func TestService_PayFromFavoriteIdempotent_RepeatIdempotent(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance := account.Balance
+
+	first, err := s.PayFromFavoriteIdempotent("key-1", favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.PayFromFavoriteIdempotent("key-1", favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != second.ID {
+		t.Errorf("PayFromFavoriteIdempotent(): retry must return original payment, returned = %v", second)
+	}
+
+	first, err = s.RepeatIdempotent("key-2", payments[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err = s.RepeatIdempotent("key-2", payments[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != second.ID {
+		t.Errorf("RepeatIdempotent(): retry must return original payment, returned = %v", second)
+	}
+
+	account = s.account(t, account.ID)
+	if account.Balance != balance-favorite.Amount-payments[1].Amount {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, balance-favorite.Amount-payments[1].Amount)
+	}
+}
+
+func TestService_PayIdempotent_retention(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetIdempotencyRetention(time.Millisecond)
+
+	first, err := s.PayIdempotent("key-1", account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(2 * time.Millisecond)
+	second, err := s.PayIdempotent("key-1", account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID {
+		t.Error("PayIdempotent(): expired key must create new payment")
+	}
+}
+
+func TestService_PayIdempotent_sweepsExpired(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetIdempotencyRetention(time.Hour)
+
+	for _, key := range []string{"key-1", "key-2"} {
+		if _, err := s.PayIdempotent(key, account.ID, 10_00, "auto"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.clock.Add(2 * time.Hour)
+	if _, err := s.PayIdempotent("key-3", account.ID, 10_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.idempotency.records) != 1 || s.idempotency.records["key-3"] == nil {
+		t.Errorf("PayIdempotent(): expired keys must be removed, records = %v", s.idempotency.records)
+	}
+}
+
+func TestService_Export_idempotency(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.PayIdempotent("key-1", account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	retry, err := imported.PayIdempotent("key-1", account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.ID != payment.ID {
+		t.Errorf("PayIdempotent(): retry after import must return original payment, returned = %v", retry)
+	}
+	got, err := imported.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Balance != 90_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", got.Balance, 90_00)
+	}
+}
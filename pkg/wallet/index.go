@@ -0,0 +1,158 @@
+package wallet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+//index хранит индексы для поиска без перебора слайсов.
+//Индексы строятся лениво из слайсов (так работает нулевое значение Service
+//и Service, собранный литералом) и дальше поддерживаются при каждом изменении.
+//Аккаунты, платежи и избранное индексируют их хранилища (см. Repositories).
+//Балансы аккаунтов, собранных литералом или загруженных хранилищем,
+//попадают в журнал как входящие остатки.
+type index struct {
+	once sync.Once
+
+	transfersByID      map[string]*types.Transfer
+	transfersByAccount map[int64][]*types.Transfer
+
+	depositsByID      map[string]*types.Deposit
+	depositsByAccount map[int64][]*types.Deposit
+
+	refundsByID      map[string]*types.Refund
+	refundsByPayment map[string][]*types.Refund
+
+	//paymentsByTime платежи аккаунтов по возрастанию Created, см. accountPaymentsSince
+	paymentsByTime map[int64][]*types.Payment
+}
+
+//indexes возвращает индексы, при первом обращении строит их по слайсам
+func (s *Service) indexes() *index {
+	s.idx.once.Do(func() {
+		s.initRepositories()
+		s.idx.transfersByID = make(map[string]*types.Transfer, len(s.transfers))
+		s.idx.transfersByAccount = make(map[int64][]*types.Transfer)
+		s.idx.depositsByID = make(map[string]*types.Deposit, len(s.deposits))
+		s.idx.depositsByAccount = make(map[int64][]*types.Deposit)
+		s.idx.refundsByID = make(map[string]*types.Refund, len(s.refunds))
+		s.idx.refundsByPayment = make(map[string][]*types.Refund)
+		s.idx.paymentsByTime = make(map[int64][]*types.Payment)
+
+		for _, account := range s.repos.Accounts.All() {
+			if account.ID > s.nextAccountID {
+				s.nextAccountID = account.ID
+			}
+			if account.Balance != 0 {
+				s.ledger.postOpening(account.ID, account.Balance)
+			}
+		}
+		for _, payment := range s.repos.Payments.All() {
+			s.idx.indexPaymentTime(payment)
+		}
+		for _, transfer := range s.transfers {
+			s.idx.transfersByID[transfer.ID] = transfer
+			s.idx.indexTransfer(transfer)
+		}
+		for _, deposit := range s.deposits {
+			s.idx.depositsByID[deposit.ID] = deposit
+			s.idx.depositsByAccount[deposit.AccountID] = append(s.idx.depositsByAccount[deposit.AccountID], deposit)
+		}
+		for _, refund := range s.refunds {
+			s.idx.refundsByID[refund.ID] = refund
+			s.idx.refundsByPayment[refund.PaymentID] = append(s.idx.refundsByPayment[refund.PaymentID], refund)
+		}
+	})
+	return &s.idx
+}
+
+//insertAccount добавляет аккаунт в хранилище
+func (s *Service) insertAccount(account *types.Account) {
+	s.repositories().Accounts.Add(account)
+	s.dirty.accounts = true
+}
+
+//setAccountPhone меняет телефон аккаунта с обновлением индекса
+func (s *Service) setAccountPhone(account *types.Account, phone types.Phone) {
+	s.repositories().Accounts.SetPhone(account, phone)
+	s.dirty.accounts = true
+}
+
+//insertPayment добавляет платёж в хранилище
+func (s *Service) insertPayment(payment *types.Payment) {
+	s.repositories().Payments.Add(payment)
+	s.idx.indexPaymentTime(payment)
+	s.dirty.payments = true
+}
+
+//setPaymentAccount переносит платёж на другой аккаунт с обновлением индекса
+func (s *Service) setPaymentAccount(payment *types.Payment, accountID int64) {
+	idx := s.indexes()
+	idx.unindexPaymentTime(payment)
+	s.repos.Payments.SetAccount(payment, accountID)
+	idx.indexPaymentTime(payment)
+	s.dirty.payments = true
+}
+
+//setPaymentCreated меняет время создания платежа с обновлением индекса по времени
+func (s *Service) setPaymentCreated(payment *types.Payment, created time.Time) {
+	idx := s.indexes()
+	idx.unindexPaymentTime(payment)
+	payment.Created = created
+	idx.indexPaymentTime(payment)
+	s.dirty.payments = true
+}
+
+//accountPaymentsSince возвращает платежи аккаунта, созданные не раньше since,
+//по возрастанию Created. Слайс нельзя изменять.
+func (s *Service) accountPaymentsSince(accountID int64, since time.Time) []*types.Payment {
+	payments := s.indexes().paymentsByTime[accountID]
+	i := sort.Search(len(payments), func(i int) bool {
+		return !payments[i].Created.Before(since)
+	})
+	return payments[i:]
+}
+
+//indexPaymentTime вставляет платёж в список аккаунта, сохраняя порядок по Created
+func (idx *index) indexPaymentTime(payment *types.Payment) {
+	payments := idx.paymentsByTime[payment.AccountID]
+	i := sort.Search(len(payments), func(i int) bool {
+		return payment.Created.Before(payments[i].Created)
+	})
+	payments = append(payments, nil)
+	copy(payments[i+1:], payments[i:])
+	payments[i] = payment
+	idx.paymentsByTime[payment.AccountID] = payments
+}
+
+//unindexPaymentTime убирает платёж из списка аккаунта
+func (idx *index) unindexPaymentTime(payment *types.Payment) {
+	idx.paymentsByTime[payment.AccountID] = withoutPayment(idx.paymentsByTime[payment.AccountID], payment)
+}
+
+//setPaymentFavorite связывает платёж с избранным с обновлением индекса
+func (s *Service) setPaymentFavorite(payment *types.Payment, favoriteID string) {
+	s.repositories().Payments.SetFavorite(payment, favoriteID)
+	s.dirty.payments = true
+}
+
+//insertFavorite добавляет избранное в хранилище
+func (s *Service) insertFavorite(favorite *types.Favorite) {
+	s.repositories().Favorites.Add(favorite)
+	s.dirty.favorites = true
+}
+
+//setFavoriteAccount переносит избранное на другой аккаунт с обновлением индекса
+func (s *Service) setFavoriteAccount(favorite *types.Favorite, accountID int64) {
+	s.repositories().Favorites.SetAccount(favorite, accountID)
+	s.dirty.favorites = true
+}
+
+//removeFavorite удаляет избранное из хранилища
+func (s *Service) removeFavorite(favorite *types.Favorite) {
+	s.repositories().Favorites.Remove(favorite)
+	s.dirty.favorites = true
+}
@@ -0,0 +1,213 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_index_afterImport(t *testing.T) {
+	dir := t.TempDir()
+	accounts := "1;+992000000001;100\n2;+992000000002;200\n"
+	if err := os.WriteFile(filepath.Join(dir, "accounts.dump"), []byte(accounts), 0666); err != nil {
+		t.Fatal(err)
+	}
+	payments := "p1;2;10;auto;INPROGRESS\n"
+	if err := os.WriteFile(filepath.Join(dir, "payments.dump"), []byte(payments), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestService()
+	if _, err := s.addAccountWithBalance("+992000000009", 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pay(1, 10, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RegisterAccount("+992000000009"); err != nil {
+		t.Errorf("RegisterAccount(): old phone must be released after import, error = %v", err)
+	}
+	if _, err := s.RegisterAccount("+992000000002"); !errors.Is(err, ErrPhoneRegistred) {
+		t.Errorf("RegisterAccount(): must return ErrPhoneRegistred, returned = %v", err)
+	}
+
+	account, err := s.FindAccountByID(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 200 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, 200)
+	}
+
+	history, err := s.ExportAccountHistory(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].ID != "p1" {
+		t.Errorf("ExportAccountHistory(): wrong payments returned = %v", history)
+	}
+
+	payments = "p1;1;10;auto;INPROGRESS\n"
+	if err := os.WriteFile(filepath.Join(dir, "payments.dump"), []byte(payments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ExportAccountHistory(2); !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("ExportAccountHistory(): must return ErrPaymentNotFound, returned = %v", err)
+	}
+	history, err = s.ExportAccountHistory(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", len(history), 2)
+	}
+}
+
+func TestService_index_paymentsByTime(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.Pay(account.ID, 10, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(time.Hour)
+	second, err := s.Pay(account.ID, 10, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	created := strconv.FormatInt(first.Created.Add(-24*time.Hour).UnixNano(), 10)
+	payments := "old;1;10;auto;OK;;0;" + created + ";" + created + ";;\n"
+	if err := os.WriteFile(filepath.Join(dir, "payments.dump"), []byte(payments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(payments []*types.Payment) []string {
+		result := []string{}
+		for _, payment := range payments {
+			result = append(result, payment.ID)
+		}
+		return result
+	}
+	if got, want := ids(s.accountPaymentsSince(account.ID, time.Time{})), []string{"old", first.ID, second.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("accountPaymentsSince(): result_we_got %v, result_we_want %v", got, want)
+	}
+	if got, want := ids(s.accountPaymentsSince(account.ID, second.Created)), []string{second.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("accountPaymentsSince(): result_we_got %v, result_we_want %v", got, want)
+	}
+}
+
+func TestService_index_favorites(t *testing.T) {
+	s := newTestService()
+	Transactions(s)
+
+	for _, favorite := range s.repositories().Favorites.All() {
+		got, err := s.GetFavoriteByID(favorite.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, favorite) {
+			t.Errorf("GetFavoriteByID(): wrong favorite returned = %v", got)
+		}
+	}
+}
+
+//benchmarkService создаёт сервис с payments платежами, из которых
+//ровно 10 принадлежат первому аккаунту
+func benchmarkService(b *testing.B, payments int) (*testService, string) {
+	b.Helper()
+	s := newTestService()
+	target, err := s.addAccountWithBalance("+992000000000", 1_000_000_00)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	accounts := make([]*types.Account, 100)
+	for i := range accounts {
+		accounts[i], err = s.addAccountWithBalance(types.Phone("+99210000"+strconv.Itoa(1000+i)), types.Money(payments)+1)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var last string
+	for i := 0; i < payments; i++ {
+		accountID := accounts[i%len(accounts)].ID
+		if i < 10 {
+			accountID = target.ID
+		}
+		payment, err := s.Pay(accountID, 1, "auto")
+		if err != nil {
+			b.Fatal(err)
+		}
+		last = payment.ID
+	}
+	return s, last
+}
+
+func BenchmarkFindPaymentByID(b *testing.B) {
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s, paymentID := benchmarkService(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.FindPaymentByID(paymentID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindAccountByID(b *testing.B) {
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s, _ := benchmarkService(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.FindAccountByID(s.nextAccountID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExportAccountHistory(b *testing.B) {
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s, _ := benchmarkService(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				history, err := s.ExportAccountHistory(1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(history) != 10 {
+					b.Fatalf("INVALID: result_we_got %v, result_we_want %v", len(history), 10)
+				}
+			}
+		})
+	}
+}
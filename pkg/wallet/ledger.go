@@ -0,0 +1,257 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrLedgerMismatch = errors.New("ledger doesn't match balances")
+
+//Системные счета журнала. Их ID отрицательные и не пересекаются с аккаунтами.
+const (
+	//LedgerCashAccountID источник пополнений
+	LedgerCashAccountID int64 = -1
+	//LedgerPaymentsAccountID счёт расчётов по платежам
+	LedgerPaymentsAccountID int64 = -2
+	//LedgerOpeningAccountID входящие остатки: балансы, пришедшие без истории (импорт)
+	LedgerOpeningAccountID int64 = -3
+	//LedgerFeesAccountID доходы от комиссий
+	LedgerFeesAccountID int64 = -4
+)
+
+//ledger журнал двойной записи
+type ledger struct {
+	entries   []*types.Entry
+	byID      map[string]*types.Entry
+	byAccount map[int64][]*types.Entry
+	balances  map[int64]types.Money
+}
+
+//post записывает пару проводок: amount списывается с debitID и зачисляется на creditID
+func (l *ledger) post(operationID string, debitID int64, creditID int64, amount types.Money) {
+	l.postEntries(operationID, uuid.New().String(), uuid.New().String(), debitID, creditID, amount)
+}
+
+//postOpening записывает входящий остаток balance аккаунта accountID. ID операции
+//и проводок строятся из ID аккаунта, поэтому сервис, заново открытый над теми же
+//хранилищами, получает тот же журнал.
+func (l *ledger) postOpening(accountID int64, balance types.Money) {
+	operationID := "opening:" + strconv.FormatInt(accountID, 10)
+	debitID, creditID, amount := LedgerOpeningAccountID, accountID, balance
+	if balance < 0 {
+		debitID, creditID, amount = accountID, LedgerOpeningAccountID, -balance
+	}
+	l.postEntries(operationID, operationID+":debit", operationID+":credit", debitID, creditID, amount)
+}
+
+//postEntries записывает пару проводок с заданными ID
+func (l *ledger) postEntries(operationID, debitEntryID, creditEntryID string, debitID int64, creditID int64, amount types.Money) {
+	l.insert(&types.Entry{
+		ID:               debitEntryID,
+		OperationID:      operationID,
+		AccountID:        debitID,
+		CounterAccountID: creditID,
+		Amount:           -amount,
+	})
+	l.insert(&types.Entry{
+		ID:               creditEntryID,
+		OperationID:      operationID,
+		AccountID:        creditID,
+		CounterAccountID: debitID,
+		Amount:           amount,
+	})
+}
+
+func (l *ledger) insert(entry *types.Entry) {
+	if l.byID == nil {
+		l.byID = make(map[string]*types.Entry)
+		l.byAccount = make(map[int64][]*types.Entry)
+		l.balances = make(map[int64]types.Money)
+	}
+	l.entries = append(l.entries, entry)
+	l.byID[entry.ID] = entry
+	l.byAccount[entry.AccountID] = append(l.byAccount[entry.AccountID], entry)
+	l.balances[entry.AccountID] += entry.Amount
+}
+
+//post проводит операцию по журналу и меняет балансы затронутых аккаунтов.
+//Это единственное место, где меняется Account.Balance.
+func (s *Service) post(operationID string, debitID int64, creditID int64, amount types.Money) {
+	accounts := s.repositories().Accounts
+	now := s.now()
+	s.ledger.post(operationID, debitID, creditID, amount)
+	if account, ok := accounts.ByID(debitID); ok {
+		account.Balance -= amount
+		account.Updated = now
+	}
+	if account, ok := accounts.ByID(creditID); ok {
+		account.Balance += amount
+		account.Updated = now
+	}
+	s.dirty.accounts = true
+	s.recordAccount("BALANCE_CHANGED", debitID)
+	s.recordAccount("BALANCE_CHANGED", creditID)
+}
+
+//setBalance приводит баланс аккаунта к balance проводкой со счёта входящих остатков
+func (s *Service) setBalance(account *types.Account, balance types.Money) {
+	diff := balance - s.ledger.balances[account.ID]
+	if diff > 0 {
+		s.ledger.post(uuid.New().String(), LedgerOpeningAccountID, account.ID, diff)
+	}
+	if diff < 0 {
+		s.ledger.post(uuid.New().String(), account.ID, LedgerOpeningAccountID, -diff)
+	}
+	account.Balance = balance
+	s.dirty.accounts = true
+	s.recordAccount("BALANCE_CHANGED", account.ID)
+}
+
+//AccountEntries возвращает проводки журнала по аккаунту
+func (s *Service) AccountEntries(accountID int64) ([]types.Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []types.Entry{}
+	for _, entry := range s.ledger.byAccount[accountID] {
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+//LedgerBalance возвращает баланс аккаунта, посчитанный по журналу
+func (s *Service) LedgerBalance(accountID int64) (types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	s.indexes()
+	return s.ledger.balances[accountID], nil
+}
+
+//VerifyLedger пересчитывает журнал и проверяет, что каждая операция сбалансирована,
+//а балансы всех аккаунтов совпадают с журналом
+func (s *Service) VerifyLedger() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.indexes()
+	operations := make(map[string]types.Money)
+	balances := make(map[int64]types.Money)
+	for _, entry := range s.ledger.entries {
+		operations[entry.OperationID] += entry.Amount
+		balances[entry.AccountID] += entry.Amount
+	}
+
+	for operationID, sum := range operations {
+		if sum != 0 {
+			return &Error{Err: ErrLedgerMismatch, ID: operationID, Amount: sum, Cause: errors.New("operation is unbalanced")}
+		}
+	}
+
+	for _, account := range s.repositories().Accounts.All() {
+		if balances[account.ID] != account.Balance {
+			return &Error{
+				Err:       ErrLedgerMismatch,
+				AccountID: account.ID,
+				Amount:    account.Balance - balances[account.ID],
+				Cause:     fmt.Errorf("balance %d, ledger %d", account.Balance, balances[account.ID]),
+			}
+		}
+	}
+	return nil
+}
+
+//exportLedger записывает журнал в ledger.dump
+func (s *Service) exportLedger(dir string) error {
+	if len(s.ledger.entries) == 0 {
+		return nil
+	}
+
+	entData := make([]byte, 0)
+
+	for _, entry := range s.ledger.entries {
+		str := entry.ID + (";") +
+			entry.OperationID + (";") +
+			strconv.FormatInt(entry.AccountID, 10) + (";") +
+			strconv.FormatInt(entry.CounterAccountID, 10) + (";") +
+			strconv.FormatInt(int64(entry.Amount), 10) + ("\n")
+
+		entData = append(entData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/ledger.dump", entData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importLedger читает журнал из ledger.dump, уже известные проводки пропускаются
+func (s *Service) importLedger(dir string) error {
+	s.indexes()
+
+	path := dir + "/ledger.dump"
+	entFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, entOperation := range strings.Split(string(entFile), "\n") {
+		if len(entOperation) == 0 {
+			break
+		}
+		entStr, err := dumpFields(path, i+1, entOperation, 5)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := entStr[0]
+		operationID := entStr[1]
+		accountID, err := strconv.ParseInt(entStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		counterID, err := strconv.ParseInt(entStr[3], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		amount, err := strconv.ParseInt(entStr[4], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		if _, ok := s.ledger.byID[id]; ok {
+			continue
+		}
+		s.ledger.insert(&types.Entry{
+			ID:               id,
+			OperationID:      operationID,
+			AccountID:        accountID,
+			CounterAccountID: counterID,
+			Amount:           types.Money(amount),
+		})
+	}
+	return nil
+}
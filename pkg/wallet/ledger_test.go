@@ -0,0 +1,171 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_VerifyLedger_success(t *testing.T) {
+	s := newTestService()
+	Transactions(s)
+
+	to, err := s.addAccountWithBalance("+992000000009", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := s.Transfer(1, to.ID, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reject(transfer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reject(s.repositories().Payments.All()[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_VerifyLedger_mismatch(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal, _ := s.repositories().Accounts.ByID(account.ID)
+	internal.Balance += 1
+
+	err = s.VerifyLedger()
+	var walletErr *Error
+	if !errors.As(err, &walletErr) || CodeOf(err) != CodeLedgerMismatch || walletErr.AccountID != account.ID || walletErr.Amount != 1 {
+		t.Errorf("VerifyLedger(): must return ErrLedgerMismatch, returned = %v", err)
+	}
+}
+
+func TestService_AccountEntries(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 30, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reject(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.AccountEntries(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		counterID int64
+		amount    types.Money
+	}{
+		{counterID: LedgerCashAccountID, amount: 100},
+		{counterID: LedgerPaymentsAccountID, amount: -30},
+		{counterID: LedgerPaymentsAccountID, amount: 30},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("INVALID: result_we_got %v, result_we_want %v", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.CounterAccountID != want[i].counterID || entry.Amount != want[i].amount {
+			t.Errorf("AccountEntries(): wrong entry returned = %v", entry)
+		}
+	}
+	if entries[1].OperationID != payment.ID || entries[2].OperationID != payment.ID {
+		t.Errorf("AccountEntries(): entries must reference payment %v", payment.ID)
+	}
+
+	balance, err := s.LedgerBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 100 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", balance, 100)
+	}
+}
+
+func TestService_AccountEntries_literal(t *testing.T) {
+	svc := Service{
+		accounts: []*types.Account{
+			{
+				ID:      1,
+				Phone:   "992900000001",
+				Balance: 300_00,
+			},
+		},
+	}
+
+	entries, err := svc.AccountEntries(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].CounterAccountID != LedgerOpeningAccountID || entries[0].Amount != 300_00 {
+		t.Errorf("AccountEntries(): wrong entries returned = %v", entries)
+	}
+	if err := svc.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Import_openingBalance(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "accounts.dump"), []byte("1;+992000000001;997250\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestService()
+	if err := s.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.AccountEntries(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].CounterAccountID != LedgerOpeningAccountID || entries[0].Amount != 997250 {
+		t.Errorf("AccountEntries(): wrong entries returned = %v", entries)
+	}
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Export_ledger(t *testing.T) {
+	s := newTestService()
+	Transactions(s)
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := imported.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+	if len(imported.ledger.entries) != len(s.ledger.entries) {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", len(imported.ledger.entries), len(s.ledger.entries))
+	}
+
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.ledger.entries) != len(s.ledger.entries) {
+		t.Errorf("Import(): repeated import must not duplicate entries, got %v", len(imported.ledger.entries))
+	}
+}
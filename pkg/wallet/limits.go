@@ -0,0 +1,296 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrLimitExceeded = errors.New("spending limit exceeded")
+var ErrInvalidLimit = errors.New("spending limit can't be negative")
+
+//Виды лимитов расходов
+const (
+	LimitDaily    = "daily"
+	LimitMonthly  = "monthly"
+	LimitCategory = "category"
+)
+
+//LimitError подробности превышения лимита расходов. Сервис возвращает *Error
+//с ErrLimitExceeded, а LimitError лежит в его Cause и достаётся через errors.As.
+type LimitError struct {
+	AccountID int64
+	//Kind вид лимита: LimitDaily, LimitMonthly или LimitCategory
+	Kind     string
+	Category types.PaymentCategory
+	Limit    types.Money
+	//Remaining сколько ещё можно потратить в текущем периоде
+	Remaining types.Money
+}
+
+func (e *LimitError) Error() string {
+	kind := e.Kind
+	if e.Kind == LimitCategory {
+		kind += " " + string(e.Category)
+	}
+	return kind + " limit " + strconv.FormatInt(int64(e.Limit), 10) +
+		", remaining " + strconv.FormatInt(int64(e.Remaining), 10)
+}
+
+//Is позволяет сравнивать ошибку с ErrLimitExceeded
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+//SetSpendingLimits задаёт лимиты расходов аккаунта. Категории лимитов могут быть
+//псевдонимами, лимит родительской категории действует и на дочерние.
+func (s *Service) SetSpendingLimits(accountID int64, limits types.SpendingLimits, opts ...CallOption) (err error) {
+	if limits.Daily < 0 {
+		return &Error{Err: ErrInvalidLimit, AccountID: accountID, ID: LimitDaily, Amount: limits.Daily}
+	}
+	if limits.Monthly < 0 {
+		return &Error{Err: ErrInvalidLimit, AccountID: accountID, ID: LimitMonthly, Amount: limits.Monthly}
+	}
+	for category, limit := range limits.Categories {
+		if limit < 0 {
+			return &Error{Err: ErrInvalidLimit, AccountID: accountID, ID: string(category), Amount: limit}
+		}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	_, err = s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	categories := make(map[types.PaymentCategory]types.Money, len(limits.Categories))
+	for category, limit := range limits.Categories {
+		code, err := s.resolveCategory(category)
+		if err != nil {
+			return err
+		}
+		//код и его псевдоним задают лимит одной категории дважды
+		if _, ok := categories[code]; ok {
+			return &Error{
+				Err:       ErrInvalidLimit,
+				AccountID: accountID,
+				ID:        string(category),
+				Cause:     errors.New("duplicate category " + string(code)),
+			}
+		}
+		categories[code] = limit
+	}
+	limits.Categories = categories
+
+	if s.limits == nil {
+		s.limits = make(map[int64]*types.SpendingLimits)
+	}
+	s.limits[accountID] = &limits
+	s.record("SET_SPENDING_LIMITS", "limits:"+strconv.FormatInt(accountID, 10), limits)
+	return nil
+}
+
+//SpendingLimits возвращает лимиты расходов аккаунта
+func (s *Service) SpendingLimits(accountID int64) (types.SpendingLimits, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return types.SpendingLimits{}, err
+	}
+
+	limits, ok := s.limits[accountID]
+	if !ok {
+		return types.SpendingLimits{}, nil
+	}
+	result := *limits
+	result.Categories = make(map[types.PaymentCategory]types.Money, len(limits.Categories))
+	for category, limit := range limits.Categories {
+		result.Categories[category] = limit
+	}
+	return result, nil
+}
+
+//checkLimits проверяет, что платёж не выходит за лимиты аккаунта
+func (s *Service) checkLimits(accountID int64, amount types.Money, category types.PaymentCategory) error {
+	limits, ok := s.limits[accountID]
+	if !ok {
+		return nil
+	}
+
+	now := s.now()
+	year, month, day := now.Date()
+	dayStart := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+
+	//лимит родительской категории распространяется на платежи дочерних
+	ancestors := s.categoryAncestors(s.canonicalCategory(category))
+	byCategory := make(map[types.PaymentCategory]types.Money, len(ancestors))
+	var daily, monthly types.Money
+	for _, payment := range s.accountPaymentsSince(accountID, monthStart) {
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		spent := payment.Amount - payment.Refunded
+		monthly += spent
+		if payment.Created.Before(dayStart) {
+			continue
+		}
+		daily += spent
+		for _, code := range ancestors {
+			if s.categoryMatches(code, payment.Category) {
+				byCategory[code] += spent
+			}
+		}
+	}
+	//активные блокировки будут списаны при Capture без повторной проверки,
+	//поэтому они занимают лимиты всех периодов
+	for _, hold := range s.holds.byAccount[accountID] {
+		if hold.Status != types.HoldStatusActive {
+			continue
+		}
+		daily += hold.Amount
+		monthly += hold.Amount
+		for _, code := range ancestors {
+			if s.categoryMatches(code, hold.Category) {
+				byCategory[code] += hold.Amount
+			}
+		}
+	}
+
+	type limitCheck struct {
+		kind     string
+		category types.PaymentCategory
+		limit    types.Money
+		spent    types.Money
+	}
+	checks := []limitCheck{
+		{kind: LimitDaily, limit: limits.Daily, spent: daily},
+		{kind: LimitMonthly, limit: limits.Monthly, spent: monthly},
+	}
+	for _, code := range ancestors {
+		checks = append(checks, limitCheck{kind: LimitCategory, category: code, limit: limits.Categories[code], spent: byCategory[code]})
+	}
+	for _, check := range checks {
+		if check.limit == 0 || check.spent+amount <= check.limit {
+			continue
+		}
+		remaining := check.limit - check.spent
+		if remaining < 0 {
+			remaining = 0
+		}
+		limitErr := &LimitError{
+			AccountID: accountID,
+			Kind:      check.kind,
+			Limit:     check.limit,
+			Remaining: remaining,
+		}
+		if check.kind == LimitCategory {
+			limitErr.Category = check.category
+		}
+		return &Error{Err: ErrLimitExceeded, AccountID: accountID, Amount: amount, Cause: limitErr}
+	}
+	return nil
+}
+
+//exportLimits записывает лимиты в limits.dump: accountID;daily;monthly;category=limit,...
+func (s *Service) exportLimits(dir string) error {
+	if len(s.limits) == 0 {
+		return nil
+	}
+
+	limData := make([]byte, 0)
+
+	for _, account := range s.repositories().Accounts.All() {
+		limits, ok := s.limits[account.ID]
+		if !ok {
+			continue
+		}
+		categories := make([]string, 0, len(limits.Categories))
+		for category, limit := range limits.Categories {
+			categories = append(categories, string(category)+"="+strconv.FormatInt(int64(limit), 10))
+		}
+		str := strconv.FormatInt(account.ID, 10) + (";") +
+			strconv.FormatInt(int64(limits.Daily), 10) + (";") +
+			strconv.FormatInt(int64(limits.Monthly), 10) + (";") +
+			strings.Join(categories, ",") + ("\n")
+
+		limData = append(limData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/limits.dump", limData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importLimits читает лимиты из limits.dump
+func (s *Service) importLimits(dir string) error {
+	path := dir + "/limits.dump"
+	limFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, limOperation := range strings.Split(string(limFile), "\n") {
+		if len(limOperation) == 0 {
+			break
+		}
+		limStr, err := dumpFields(path, i+1, limOperation, 4)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		accountID, err := strconv.ParseInt(limStr[0], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		daily, err := strconv.ParseInt(limStr[1], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		monthly, err := strconv.ParseInt(limStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		limits := &types.SpendingLimits{
+			Daily:      types.Money(daily),
+			Monthly:    types.Money(monthly),
+			Categories: make(map[types.PaymentCategory]types.Money),
+		}
+		if limStr[3] != "" {
+			for _, categoryLimit := range strings.Split(limStr[3], ",") {
+				sep := strings.LastIndex(categoryLimit, "=")
+				if sep < 0 {
+					continue
+				}
+				limit, err := strconv.ParseInt(categoryLimit[sep+1:], 10, 64)
+				if err != nil {
+					log.Print(err)
+					return dumpError(path, i+1, err)
+				}
+				limits.Categories[types.PaymentCategory(categoryLimit[:sep])] = types.Money(limit)
+			}
+		}
+
+		if s.limits == nil {
+			s.limits = make(map[int64]*types.SpendingLimits)
+		}
+		s.limits[accountID] = limits
+	}
+	return nil
+}
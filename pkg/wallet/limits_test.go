@@ -0,0 +1,141 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_Pay_dailyCategoryLimit(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetSpendingLimits(account.ID, types.SpendingLimits{
+		Categories: map[types.PaymentCategory]types.Money{"cafe": 500},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Pay(account.ID, 300, "cafe"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(account.ID, 300, "cafe")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Pay(): must return LimitError, returned = %v", err)
+	}
+	if limitErr.Kind != LimitCategory || limitErr.Category != "cafe" || limitErr.Remaining != 200 {
+		t.Errorf("Pay(): wrong error = %v", limitErr)
+	}
+	if _, err := s.Pay(account.ID, 300, "auto"); err != nil {
+		t.Errorf("Pay(): other categories must not be limited, returned = %v", err)
+	}
+
+	s.clock.Add(24 * time.Hour)
+	if _, err := s.Pay(account.ID, 500, "cafe"); err != nil {
+		t.Errorf("Pay(): limit must reset next day, returned = %v", err)
+	}
+}
+
+func TestService_Pay_dailyAndMonthlyLimit(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetSpendingLimits(account.ID, types.SpendingLimits{Daily: 1_000, Monthly: 2_500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := s.Pay(account.ID, 1_000, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Repeat(payment.ID)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Kind != LimitDaily || limitErr.Remaining != 0 {
+		t.Fatalf("Repeat(): must return daily LimitError, returned = %v", err)
+	}
+
+	if err := s.Reject(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Repeat(payment.ID); err != nil {
+		t.Errorf("Repeat(): rejected payments must not count, returned = %v", err)
+	}
+
+	favorite, err := s.FavoritePayment(payment.ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(24 * time.Hour)
+	if _, err := s.PayFromFavorite(favorite.ID); err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(24 * time.Hour)
+	_, err = s.PayFromFavorite(favorite.ID)
+	if !errors.As(err, &limitErr) || limitErr.Kind != LimitMonthly || limitErr.Remaining != 500 {
+		t.Errorf("PayFromFavorite(): must return monthly LimitError, returned = %v", err)
+	}
+}
+
+func TestService_SetSpendingLimits_invalid(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.SetSpendingLimits(account.ID, types.SpendingLimits{Daily: -1})
+	if !errors.Is(err, ErrInvalidLimit) {
+		t.Errorf("SetSpendingLimits(): must return ErrInvalidLimit, returned = %v", err)
+	}
+	err = s.SetSpendingLimits(account.ID, types.SpendingLimits{Categories: map[types.PaymentCategory]types.Money{"cafe": -1}})
+	if !errors.Is(err, ErrInvalidLimit) {
+		t.Errorf("SetSpendingLimits(): must return ErrInvalidLimit, returned = %v", err)
+	}
+	err = s.SetSpendingLimits(account.ID+1, types.SpendingLimits{})
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("SetSpendingLimits(): must return ErrAccountNotFound, returned = %v", err)
+	}
+}
+
+func TestService_Export_limits(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := types.SpendingLimits{
+		Daily:      1_000,
+		Monthly:    10_000,
+		Categories: map[types.PaymentCategory]types.Money{"cafe": 500, "auto": 700},
+	}
+	if err := s.SetSpendingLimits(account.ID, limits); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := imported.SpendingLimits(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, limits) {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", got, limits)
+	}
+}
@@ -0,0 +1,120 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrInvalidOverdraftLimit = errors.New("overdraft limit can't be negative")
+
+//OverdraftFeeFunc считает комиссию или проценты для счёта с отрицательным балансом.
+//Вызывается из ChargeOverdraftFees под блокировкой сервиса, поэтому не должна вызывать его методы.
+//Нулевой или отрицательный результат ничего не списывает.
+type OverdraftFeeFunc func(account types.Account) types.Money
+
+//OverdraftInterest возвращает OverdraftFeeFunc, начисляющую rate базисных пунктов
+//(1% = 100) от суммы долга, с округлением вверх
+func OverdraftInterest(rate int64) OverdraftFeeFunc {
+	return func(account types.Account) types.Money {
+		debt := int64(-account.Balance)
+		return types.Money((debt*rate + 9_999) / 10_000)
+	}
+}
+
+//canDebit проверяет, можно ли списать amount с учётом лимита овердрафта
+//и блокировок. Просроченные блокировки аккаунта перед проверкой снимаются.
+func (s *Service) canDebit(account *types.Account, amount types.Money) bool {
+	s.expireAccountHolds(account.ID)
+	return account.Available()+account.OverdraftLimit >= amount
+}
+
+//SetOverdraftLimit задаёт, насколько баланс аккаунта может уйти в минус
+func (s *Service) SetOverdraftLimit(accountID int64, limit types.Money, opts ...CallOption) (err error) {
+	if limit < 0 {
+		return &Error{Err: ErrInvalidOverdraftLimit, AccountID: accountID, Amount: limit}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	account.OverdraftLimit = limit
+	account.Updated = s.now()
+	s.dirty.accounts = true
+	s.record("SET_OVERDRAFT_LIMIT", auditAccount(account.ID), *account)
+	return nil
+}
+
+//SetOverdraftFee задаёт правило начисления комиссии за овердрафт
+func (s *Service) SetOverdraftFee(fee OverdraftFeeFunc) {
+	s.mu.Lock()
+	defer s.unlock()
+
+	s.overdraftFee = fee
+}
+
+//ChargeOverdraftFees списывает комиссию со всех счетов в минусе.
+//Комиссия может увести баланс ниже лимита овердрафта.
+//Если изменения не удалось сохранить, проведённые списания возвращаются вместе с ошибкой.
+func (s *Service) ChargeOverdraftFees(opts ...CallOption) (_ []types.OverdraftCharge, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	charges := []types.OverdraftCharge{}
+	if s.overdraftFee == nil {
+		return charges, nil
+	}
+
+	for _, account := range s.repositories().Accounts.All() {
+		if account.Balance >= 0 {
+			continue
+		}
+		amount := s.overdraftFee(*account)
+		if amount <= 0 {
+			continue
+		}
+
+		charge := types.OverdraftCharge{
+			ID:        uuid.New().String(),
+			AccountID: account.ID,
+			Balance:   account.Balance,
+			Amount:    amount,
+			Created:   s.now(),
+		}
+		s.post(charge.ID, account.ID, LedgerFeesAccountID, amount)
+		s.record("OVERDRAFT_CHARGED", "overdraft_charge:"+charge.ID, charge)
+		s.publish(types.Event{
+			Type:      types.EventOverdraftCharged,
+			AccountID: account.ID,
+			EntityID:  charge.ID,
+			Amount:    amount,
+		})
+		charges = append(charges, charge)
+	}
+	return charges, nil
+}
+
+//OverdraftAccounts возвращает счета с отрицательным балансом, начиная с самого большого долга
+func (s *Service) OverdraftAccounts() []types.Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := []types.Account{}
+	for _, account := range s.repositories().Accounts.All() {
+		if account.Balance < 0 {
+			view, _ := s.accountView(account, nil)
+			accounts = append(accounts, *view)
+		}
+	}
+	sort.SliceStable(accounts, func(i, j int) bool {
+		return accounts[i].Balance < accounts[j].Balance
+	})
+	return accounts
+}
@@ -0,0 +1,173 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_Pay_overdraft(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetOverdraftLimit(account.ID, -1); !errors.Is(err, ErrInvalidOverdraftLimit) {
+		t.Errorf("SetOverdraftLimit(): must return ErrInvalidOverdraftLimit, returned = %v", err)
+	}
+	if err := s.SetOverdraftLimit(account.ID, 50_00); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Pay(account.ID, 60_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != -50_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, -50_00)
+	}
+	if _, err := s.Pay(account.ID, 1, "auto"); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+
+	to, err := s.RegisterAccount("+992000000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Transfer(account.ID, to.ID, 1); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Transfer(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_ChargeOverdraftFees(t *testing.T) {
+	s := newTestService()
+	first, err := s.addAccountWithBalance("+992000000001", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.addAccountWithBalance("+992000000002", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range []*types.Account{first, second} {
+		if err := s.SetOverdraftLimit(account.ID, 100_00); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if charges, err := s.ChargeOverdraftFees(); err != nil || len(charges) != 0 {
+		t.Errorf("ChargeOverdraftFees(): without fee nothing must be charged = %v, err = %v", charges, err)
+	}
+
+	if _, err := s.Pay(first.ID, 60_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	s.SetOverdraftFee(OverdraftInterest(150))
+	events := []types.Event{}
+	s.Subscribe(EventFilter{Types: []types.EventType{types.EventOverdraftCharged}}, func(event types.Event) {
+		events = append(events, event)
+	})
+
+	charges, err := s.ChargeOverdraftFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charges) != 1 || charges[0].AccountID != first.ID || charges[0].Amount != 75 || charges[0].Balance != -50_00 {
+		t.Fatalf("ChargeOverdraftFees(): wrong charges = %v", charges)
+	}
+	if len(events) != 1 || events[0].AccountID != first.ID || events[0].EntityID != charges[0].ID || events[0].Amount != 75 {
+		t.Errorf("ChargeOverdraftFees(): wrong events = %v", events)
+	}
+	first, second = s.account(t, first.ID), s.account(t, second.ID)
+	if first.Balance != -50_75 || second.Balance != 10_00 {
+		t.Errorf("ChargeOverdraftFees(): wrong balances first = %v, second = %v", first.Balance, second.Balance)
+	}
+
+	entries, err := s.AccountEntries(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := entries[len(entries)-1]
+	if last.OperationID != charges[0].ID || last.CounterAccountID != LedgerFeesAccountID || last.Amount != -75 {
+		t.Errorf("AccountEntries(): wrong fee entry = %v", last)
+	}
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_ChargeOverdraftFees_syncError(t *testing.T) {
+	accounts := &countingAccounts{MemoryAccountRepository: NewMemoryAccountRepository(
+		&types.Account{ID: 1, Phone: "+992000000001", Balance: -50_00, OverdraftLimit: 100_00},
+	)}
+	s := NewService(Repositories{Accounts: accounts})
+	s.SetOverdraftFee(OverdraftInterest(150))
+	accounts.err = errors.New("disk full")
+
+	charges, err := s.ChargeOverdraftFees()
+	if !errors.Is(err, ErrRepositorySync) {
+		t.Errorf("ChargeOverdraftFees(): must return ErrRepositorySync, returned = %v", err)
+	}
+	if len(charges) != 1 || charges[0].Amount != 75 {
+		t.Errorf("ChargeOverdraftFees(): charges must be returned with the error = %v", charges)
+	}
+}
+
+func TestService_OverdraftAccounts(t *testing.T) {
+	s := newTestService()
+	var accounts []*types.Account
+	for i, phone := range []types.Phone{"+992000000001", "+992000000002", "+992000000003"} {
+		account, err := s.addAccountWithBalance(phone, 10_00)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetOverdraftLimit(account.ID, 100_00); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			if _, err := s.Pay(account.ID, types.Money(i*20_00), "auto"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		accounts = append(accounts, s.account(t, account.ID))
+	}
+
+	got := s.OverdraftAccounts()
+	want := []types.Account{*accounts[2], *accounts[1]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", got, want)
+	}
+}
+
+func TestService_Export_overdraftLimit(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetOverdraftLimit(account.ID, 50_00); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := imported.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OverdraftLimit != 50_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", got.OverdraftLimit, 50_00)
+	}
+}
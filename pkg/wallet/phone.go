@@ -0,0 +1,71 @@
+package wallet
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrPhoneNotRegistred = errors.New("phone not registred")
+var ErrInvalidPhone = errors.New("invalid phone")
+var ErrSelfTransfer = errors.New("can't transfer to the same account")
+
+//CountryCode код страны, который добавляется к локальным номерам
+const CountryCode = "992"
+
+//localPhoneLength длина номера без кода страны
+const localPhoneLength = 9
+
+//NormalizePhone приводит номер к виду 992XXXXXXXXX: убирает пробелы, скобки,
+//дефисы и плюс, международный префикс 00 и добавляет код страны к локальному номеру.
+//Номер, который уже начинается с кода страны, считается полным и не дополняется,
+//поэтому 992000001 остаётся 992000001, а не превращается в 992992000001.
+//Локальный номер, начинающийся с 992, нужно передавать вместе с кодом страны.
+func NormalizePhone(phone types.Phone) types.Phone {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, string(phone))
+
+	digits = strings.TrimPrefix(digits, "00")
+	if len(digits) == localPhoneLength && !strings.HasPrefix(digits, CountryCode) {
+		digits = CountryCode + digits
+	}
+	return types.Phone(digits)
+}
+
+//FindAccountByPhone поиск аккаунта по номеру телефона в любом формате
+func (s *Service) FindAccountByPhone(phone types.Phone) (*types.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.accountView(s.findAccountByPhone(phone))
+}
+
+func (s *Service) findAccountByPhone(phone types.Phone) (*types.Account, error) {
+	account, ok := s.repositories().Accounts.ByPhone(phone)
+	if !ok {
+		return nil, &Error{Err: ErrPhoneNotRegistred, ID: string(phone)}
+	}
+	return account, nil
+}
+
+//TransferToPhone переводит деньги со счёта fromID на счёт, зарегистрированный на номер phone
+func (s *Service) TransferToPhone(fromID int64, phone types.Phone, amount types.Money, opts ...CallOption) (_ *types.Transfer, err error) {
+	if NormalizePhone(phone) == "" {
+		return nil, &Error{Err: ErrInvalidPhone, AccountID: fromID, ID: string(phone)}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	to, err := s.findAccountByPhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyTransfer(s.transfer(fromID, to.ID, amount))
+}
@@ -0,0 +1,100 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone types.Phone
+		want  types.Phone
+	}{
+		{phone: "+992 92 000 0001", want: "992920000001"},
+		{phone: "992920000001", want: "992920000001"},
+		{phone: "920000001", want: "992920000001"},
+		{phone: "992000001", want: "992000001"},
+		{phone: "+992 000 001", want: "992000001"},
+		{phone: "+992 992 000 001", want: "992992000001"},
+		{phone: "92-000-00-01", want: "992920000001"},
+		{phone: "00992 (92) 000-00-01", want: "992920000001"},
+		{phone: "1111", want: "1111"},
+		{phone: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizePhone(tt.phone); got != tt.want {
+			t.Errorf("NormalizePhone(%q): result_we_got %v, result_we_want %v", tt.phone, got, tt.want)
+		}
+	}
+}
+
+func TestService_RegisterAccount_normalizedPhone(t *testing.T) {
+	s := newTestService()
+	if _, err := s.RegisterAccount("+992 92 000 0001"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterAccount("920000001"); !errors.Is(err, ErrPhoneRegistred) {
+		t.Errorf("RegisterAccount(): must return ErrPhoneRegistred, returned = %v", err)
+	}
+}
+
+func TestService_TransferToPhone_success(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992 92 000 0001", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.TransferToPhone(from.ID, "920000001", 30_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.ToAccountID != to.ID {
+		t.Errorf("TransferToPhone(): wrong recipient = %v", transfer.ToAccountID)
+	}
+	from, to = s.account(t, from.ID), s.account(t, to.ID)
+	if from.Balance != 70_00 || to.Balance != 30_00+1 {
+		t.Errorf("TransferToPhone(): wrong balances from = %v, to = %v", from.Balance, to.Balance)
+	}
+}
+
+func TestService_TransferToPhone_notRegistred(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.TransferToPhone(from.ID, "+992 92 000 0001", 30_00)
+	if !errors.Is(err, ErrPhoneNotRegistred) {
+		t.Errorf("TransferToPhone(): must return ErrPhoneNotRegistred, returned = %v", err)
+	}
+
+	_, err = s.TransferToPhone(from.ID, "+-()", 30_00)
+	if !errors.Is(err, ErrInvalidPhone) {
+		t.Errorf("TransferToPhone(): must return ErrInvalidPhone, returned = %v", err)
+	}
+}
+
+func TestService_TransferToPhone_self(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992 92 000 0001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.TransferToPhone(from.ID, "920000001", 30_00)
+	if !errors.Is(err, ErrSelfTransfer) {
+		t.Errorf("TransferToPhone(): must return ErrSelfTransfer, returned = %v", err)
+	}
+	if from.Balance != 100_00 {
+		t.Errorf("TransferToPhone(): balance must not change = %v", from.Balance)
+	}
+}
@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrRefundExceedsPayment = errors.New("refund exceeds payment amount")
+var ErrRefundNotFound = errors.New("refund not found")
+
+//Refund возвращает часть или всю сумму проведённого платежа на счёт.
+//Сумма всех возвратов не может превышать сумму платежа.
+//Комиссия за платёж возвращается, а вознаграждения отменяются пропорционально возвращённой сумме.
+func (s *Service) Refund(paymentID string, amount types.Money, opts ...CallOption) (_ *types.Refund, err error) {
+	if amount <= 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, ID: paymentID, Amount: amount}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.findAccountByID(payment.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if payment.Refunded+amount > payment.Amount {
+		return nil, &Error{Err: ErrRefundExceedsPayment, AccountID: account.ID, ID: payment.ID, Amount: amount}
+	}
+
+	status := types.PaymentStatusPartiallyRefunded
+	if payment.Refunded+amount == payment.Amount {
+		status = types.PaymentStatusRefunded
+	}
+	err = s.transition(payment, status)
+	if err != nil {
+		return nil, err
+	}
+
+	refund := &types.Refund{
+		ID:        uuid.New().String(),
+		PaymentID: payment.ID,
+		AccountID: account.ID,
+		Amount:    amount,
+		Created:   s.now(),
+	}
+	payment.Refunded += amount
+	s.dirty.payments = true
+	s.insertRefund(refund)
+	s.post(refund.ID, LedgerPaymentsAccountID, account.ID, amount)
+	s.refundFee(payment, payment.Refunded)
+	s.reverseRewards(payment, payment.Refunded)
+	s.publish(types.Event{
+		Type:      types.EventPaymentRefunded,
+		AccountID: account.ID,
+		EntityID:  payment.ID,
+		Amount:    amount,
+	})
+
+	return copyRefund(refund, nil)
+}
+
+//FindRefundByID поиск возврата по ID
+func (s *Service) FindRefundByID(refundID string) (*types.Refund, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	refund, ok := s.indexes().refundsByID[refundID]
+	if !ok {
+		return nil, &Error{Err: ErrRefundNotFound, ID: refundID}
+	}
+	return copyRefund(refund, nil)
+}
+
+//PaymentRefunds возвращает все возвраты по платежу
+func (s *Service) PaymentRefunds(paymentID string) ([]types.Refund, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	refunds := []types.Refund{}
+	for _, refund := range s.indexes().refundsByPayment[paymentID] {
+		refunds = append(refunds, *refund)
+	}
+	return refunds, nil
+}
+
+//insertRefund добавляет возврат в хранилище и индексы
+func (s *Service) insertRefund(refund *types.Refund) {
+	idx := s.indexes()
+	s.refunds = append(s.refunds, refund)
+	idx.refundsByID[refund.ID] = refund
+	idx.refundsByPayment[refund.PaymentID] = append(idx.refundsByPayment[refund.PaymentID], refund)
+}
+
+//exportRefunds записывает возвраты в refunds.dump
+func (s *Service) exportRefunds(dir string) error {
+	if len(s.refunds) == 0 {
+		return nil
+	}
+
+	refData := make([]byte, 0)
+
+	for _, refund := range s.refunds {
+		str := refund.ID + (";") +
+			refund.PaymentID + (";") +
+			strconv.FormatInt(refund.AccountID, 10) + (";") +
+			strconv.FormatInt(int64(refund.Amount), 10) + (";") +
+			formatTime(refund.Created) + ("\n")
+
+		refData = append(refData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/refunds.dump", refData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importRefunds читает возвраты из refunds.dump, уже известные возвраты пропускаются
+func (s *Service) importRefunds(dir string) error {
+	path := dir + "/refunds.dump"
+	refFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, refOperation := range strings.Split(string(refFile), "\n") {
+		if len(refOperation) == 0 {
+			break
+		}
+		refStr, err := dumpFields(path, i+1, refOperation, 4)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := refStr[0]
+		paymentID := refStr[1]
+		accountID, err := strconv.ParseInt(refStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		amount, err := strconv.ParseInt(refStr[3], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		created, _, err := parseTimes(refStr, 4)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		if _, ok := s.indexes().refundsByID[id]; ok {
+			continue
+		}
+		s.insertRefund(&types.Refund{
+			ID:        id,
+			PaymentID: paymentID,
+			AccountID: accountID,
+			Amount:    types.Money(amount),
+			Created:   created,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_Refund_partialAndFull(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := payments[0]
+	balance := account.Balance
+
+	if _, err := s.Refund(payment.ID, 1_00); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Refund(): payment in progress must not be refunded, returned = %v", err)
+	}
+	if err := s.Complete(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Refund(payment.ID, 4_00); err != nil {
+		t.Fatal(err)
+	}
+	payment = s.payment(t, payment.ID)
+	if payment.Status != types.PaymentStatusPartiallyRefunded || payment.Refunded != 4_00 {
+		t.Errorf("Refund(): wrong payment = %v", payment)
+	}
+
+	if _, err := s.Refund(payment.ID, 7_00); !errors.Is(err, ErrRefundExceedsPayment) {
+		t.Errorf("Refund(): must return ErrRefundExceedsPayment, returned = %v", err)
+	}
+
+	if _, err := s.Refund(payment.ID, 6_00); err != nil {
+		t.Fatal(err)
+	}
+	payment = s.payment(t, payment.ID)
+	if payment.Status != types.PaymentStatusRefunded || payment.Refunded != 10_00 {
+		t.Errorf("Refund(): wrong payment = %v", payment)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != balance+10_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, balance+10_00)
+	}
+
+	if _, err := s.Refund(payment.ID, 1); !errors.Is(err, ErrRefundExceedsPayment) {
+		t.Errorf("Refund(): must return ErrRefundExceedsPayment, returned = %v", err)
+	}
+	if err := s.Reject(payment.ID); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Reject(): refunded payment must not be rejected, returned = %v", err)
+	}
+
+	refunds, err := s.PaymentRefunds(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 2 || refunds[0].Amount != 4_00 || refunds[1].Amount != 6_00 {
+		t.Errorf("PaymentRefunds(): wrong refunds returned = %v", refunds)
+	}
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Reject_partiallyRefunded(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := payments[0]
+	balance := account.Balance
+
+	if err := s.Complete(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refund(payment.ID, 3_00); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reject(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != balance+10_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, balance+10_00)
+	}
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_Refund_notFound(t *testing.T) {
+	s := newTestService()
+	if _, err := s.Refund("unknown", 1); !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("Refund(): must return ErrPaymentNotFound, returned = %v", err)
+	}
+	if _, err := s.Refund("unknown", 0); !errors.Is(err, ErrAmmountMustBePositive) {
+		t.Errorf("Refund(): must return ErrAmmountMustBePositive, returned = %v", err)
+	}
+}
+
+func TestService_Export_refunds(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := payments[0]
+	if err := s.Complete(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	refund, err := s.Refund(payment.ID, 3_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := imported.FindRefundByID(refund.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, refund) {
+		t.Errorf("FindRefundByID(): wrong refund returned = %v", got)
+	}
+	if _, err := imported.Refund(payment.ID, 8_00); !errors.Is(err, ErrRefundExceedsPayment) {
+		t.Errorf("Refund(): must return ErrRefundExceedsPayment, returned = %v", err)
+	}
+	if _, err := imported.Refund(payment.ID, 7_00); err != nil {
+		t.Fatal(err)
+	}
+	if err := imported.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
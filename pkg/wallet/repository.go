@@ -0,0 +1,321 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+//AccountRepository хранилище аккаунтов.
+//Хранилище отдаёт одни и те же указатели на сущности, сервис меняет их на месте
+//и после изменившего хранилище метода вызывает Sync, чтобы хранилище сохранило изменения.
+//Ошибку Sync этот метод возвращает как ErrRepositorySync.
+//Сервис вызывает методы хранилищ под своей блокировкой: изменяющие - по одному,
+//методы чтения могут вызываться одновременно из нескольких горутин.
+type AccountRepository interface {
+	Add(account *types.Account)
+	ByID(accountID int64) (*types.Account, bool)
+	//ByPhone ищет аккаунт по нормализованному телефону (см. NormalizePhone)
+	ByPhone(phone types.Phone) (*types.Account, bool)
+	//SetPhone меняет телефон аккаунта вместе с индексом по телефону
+	SetPhone(account *types.Account, phone types.Phone)
+	//All возвращает все аккаунты в порядке добавления, слайс нельзя изменять
+	All() []*types.Account
+	Sync() error
+}
+
+//PaymentRepository хранилище платежей, см. AccountRepository
+type PaymentRepository interface {
+	Add(payment *types.Payment)
+	ByID(paymentID string) (*types.Payment, bool)
+	ByAccount(accountID int64) []*types.Payment
+	ByFavorite(favoriteID string) []*types.Payment
+	//SetAccount переносит платёж на другой аккаунт вместе с индексом
+	SetAccount(payment *types.Payment, accountID int64)
+	//SetFavorite связывает платёж с избранным вместе с индексом
+	SetFavorite(payment *types.Payment, favoriteID string)
+	All() []*types.Payment
+	Sync() error
+}
+
+//FavoriteRepository хранилище избранных платежей, см. AccountRepository
+type FavoriteRepository interface {
+	Add(favorite *types.Favorite)
+	Remove(favorite *types.Favorite)
+	ByID(favoriteID string) (*types.Favorite, bool)
+	ByAccount(accountID int64) []*types.Favorite
+	//SetAccount переносит избранное на другой аккаунт вместе с индексом
+	SetAccount(favorite *types.Favorite, accountID int64)
+	All() []*types.Favorite
+	Sync() error
+}
+
+var ErrRepositorySync = errors.New("repository sync failed")
+
+//Repositories хранилища сервиса. Незаданное хранилище заменяется хранилищем в памяти.
+type Repositories struct {
+	Accounts  AccountRepository
+	Payments  PaymentRepository
+	Favorites FavoriteRepository
+}
+
+//NewService создаёт сервис поверх переданных хранилищ.
+//Балансы уже сохранённых аккаунтов проводятся по журналу как входящие остатки.
+func NewService(repositories Repositories) *Service {
+	return &Service{repos: repositories}
+}
+
+//repositories возвращает хранилища, при первом обращении создаёт недостающие
+func (s *Service) repositories() *Repositories {
+	s.indexes()
+	return &s.repos
+}
+
+//initRepositories создаёт хранилища в памяти из слайсов Service, собранного литералом
+func (s *Service) initRepositories() {
+	if s.repos.Accounts == nil {
+		s.repos.Accounts = NewMemoryAccountRepository(s.accounts...)
+	}
+	if s.repos.Payments == nil {
+		s.repos.Payments = NewMemoryPaymentRepository(s.payments...)
+	}
+	if s.repos.Favorites == nil {
+		s.repos.Favorites = NewMemoryFavoriteRepository(s.favorites...)
+	}
+	s.accounts, s.payments, s.favorites = nil, nil, nil
+}
+
+//dirtyRepositories хранилища, изменённые под текущей блокировкой
+type dirtyRepositories struct {
+	accounts  bool
+	payments  bool
+	favorites bool
+}
+
+//syncRepositories сохраняет изменённые хранилища, вызывающий должен держать s.mu на запись.
+//Хранилище, которое не удалось сохранить, остаётся изменённым и сохраняется при следующей блокировке.
+//Возвращает первую ошибку сохранения.
+func (s *Service) syncRepositories() error {
+	repos := s.repositories()
+	var err error
+	sync := func(dirty *bool, repo interface{ Sync() error }) {
+		if !*dirty {
+			return
+		}
+		if syncErr := repo.Sync(); syncErr != nil {
+			log.Print(syncErr)
+			if err == nil {
+				err = &Error{Err: ErrRepositorySync, Cause: syncErr}
+			}
+			return
+		}
+		*dirty = false
+	}
+	sync(&s.dirty.accounts, repos.Accounts)
+	sync(&s.dirty.payments, repos.Payments)
+	sync(&s.dirty.favorites, repos.Favorites)
+	return err
+}
+
+//MemoryAccountRepository хранилище аккаунтов в памяти
+type MemoryAccountRepository struct {
+	accounts []*types.Account
+	byID     map[int64]*types.Account
+	byPhone  map[types.Phone]*types.Account
+}
+
+//NewMemoryAccountRepository создаёт хранилище аккаунтов в памяти
+func NewMemoryAccountRepository(accounts ...*types.Account) *MemoryAccountRepository {
+	r := &MemoryAccountRepository{
+		byID:    make(map[int64]*types.Account, len(accounts)),
+		byPhone: make(map[types.Phone]*types.Account, len(accounts)),
+	}
+	for _, account := range accounts {
+		r.Add(account)
+	}
+	return r
+}
+
+func (r *MemoryAccountRepository) Add(account *types.Account) {
+	r.accounts = append(r.accounts, account)
+	r.byID[account.ID] = account
+	r.byPhone[NormalizePhone(account.Phone)] = account
+}
+
+func (r *MemoryAccountRepository) ByID(accountID int64) (*types.Account, bool) {
+	account, ok := r.byID[accountID]
+	return account, ok
+}
+
+func (r *MemoryAccountRepository) ByPhone(phone types.Phone) (*types.Account, bool) {
+	account, ok := r.byPhone[NormalizePhone(phone)]
+	return account, ok
+}
+
+func (r *MemoryAccountRepository) SetPhone(account *types.Account, phone types.Phone) {
+	if r.byPhone[NormalizePhone(account.Phone)] == account {
+		delete(r.byPhone, NormalizePhone(account.Phone))
+	}
+	account.Phone = phone
+	r.byPhone[NormalizePhone(phone)] = account
+}
+
+func (r *MemoryAccountRepository) All() []*types.Account {
+	return r.accounts
+}
+
+//Sync ничего не делает, данные в памяти всегда актуальны
+func (r *MemoryAccountRepository) Sync() error {
+	return nil
+}
+
+//MemoryPaymentRepository хранилище платежей в памяти
+type MemoryPaymentRepository struct {
+	payments   []*types.Payment
+	byID       map[string]*types.Payment
+	byAccount  map[int64][]*types.Payment
+	byFavorite map[string][]*types.Payment
+}
+
+//NewMemoryPaymentRepository создаёт хранилище платежей в памяти
+func NewMemoryPaymentRepository(payments ...*types.Payment) *MemoryPaymentRepository {
+	r := &MemoryPaymentRepository{
+		byID:       make(map[string]*types.Payment, len(payments)),
+		byAccount:  make(map[int64][]*types.Payment),
+		byFavorite: make(map[string][]*types.Payment),
+	}
+	for _, payment := range payments {
+		r.Add(payment)
+	}
+	return r
+}
+
+func (r *MemoryPaymentRepository) Add(payment *types.Payment) {
+	r.payments = append(r.payments, payment)
+	r.byID[payment.ID] = payment
+	r.byAccount[payment.AccountID] = append(r.byAccount[payment.AccountID], payment)
+	if payment.FavoriteID != "" {
+		r.byFavorite[payment.FavoriteID] = append(r.byFavorite[payment.FavoriteID], payment)
+	}
+}
+
+func (r *MemoryPaymentRepository) ByID(paymentID string) (*types.Payment, bool) {
+	payment, ok := r.byID[paymentID]
+	return payment, ok
+}
+
+func (r *MemoryPaymentRepository) ByAccount(accountID int64) []*types.Payment {
+	return r.byAccount[accountID]
+}
+
+func (r *MemoryPaymentRepository) ByFavorite(favoriteID string) []*types.Payment {
+	return r.byFavorite[favoriteID]
+}
+
+func (r *MemoryPaymentRepository) SetAccount(payment *types.Payment, accountID int64) {
+	if payment.AccountID == accountID {
+		return
+	}
+	r.byAccount[payment.AccountID] = withoutPayment(r.byAccount[payment.AccountID], payment)
+	payment.AccountID = accountID
+	r.byAccount[accountID] = append(r.byAccount[accountID], payment)
+}
+
+func (r *MemoryPaymentRepository) SetFavorite(payment *types.Payment, favoriteID string) {
+	if payment.FavoriteID == favoriteID {
+		return
+	}
+	r.byFavorite[payment.FavoriteID] = withoutPayment(r.byFavorite[payment.FavoriteID], payment)
+	payment.FavoriteID = favoriteID
+	if favoriteID != "" {
+		r.byFavorite[favoriteID] = append(r.byFavorite[favoriteID], payment)
+	}
+}
+
+func (r *MemoryPaymentRepository) All() []*types.Payment {
+	return r.payments
+}
+
+//Sync ничего не делает, данные в памяти всегда актуальны
+func (r *MemoryPaymentRepository) Sync() error {
+	return nil
+}
+
+//MemoryFavoriteRepository хранилище избранных платежей в памяти
+type MemoryFavoriteRepository struct {
+	favorites []*types.Favorite
+	byID      map[string]*types.Favorite
+	byAccount map[int64][]*types.Favorite
+}
+
+//NewMemoryFavoriteRepository создаёт хранилище избранных платежей в памяти
+func NewMemoryFavoriteRepository(favorites ...*types.Favorite) *MemoryFavoriteRepository {
+	r := &MemoryFavoriteRepository{
+		byID:      make(map[string]*types.Favorite, len(favorites)),
+		byAccount: make(map[int64][]*types.Favorite),
+	}
+	for _, favorite := range favorites {
+		r.Add(favorite)
+	}
+	return r
+}
+
+func (r *MemoryFavoriteRepository) Add(favorite *types.Favorite) {
+	r.favorites = append(r.favorites, favorite)
+	r.byID[favorite.ID] = favorite
+	r.byAccount[favorite.AccountID] = append(r.byAccount[favorite.AccountID], favorite)
+}
+
+func (r *MemoryFavoriteRepository) Remove(favorite *types.Favorite) {
+	r.favorites = withoutFavorite(r.favorites, favorite)
+	delete(r.byID, favorite.ID)
+	r.byAccount[favorite.AccountID] = withoutFavorite(r.byAccount[favorite.AccountID], favorite)
+}
+
+func (r *MemoryFavoriteRepository) ByID(favoriteID string) (*types.Favorite, bool) {
+	favorite, ok := r.byID[favoriteID]
+	return favorite, ok
+}
+
+func (r *MemoryFavoriteRepository) ByAccount(accountID int64) []*types.Favorite {
+	return r.byAccount[accountID]
+}
+
+func (r *MemoryFavoriteRepository) SetAccount(favorite *types.Favorite, accountID int64) {
+	if favorite.AccountID == accountID {
+		return
+	}
+	r.byAccount[favorite.AccountID] = withoutFavorite(r.byAccount[favorite.AccountID], favorite)
+	favorite.AccountID = accountID
+	r.byAccount[accountID] = append(r.byAccount[accountID], favorite)
+}
+
+func (r *MemoryFavoriteRepository) All() []*types.Favorite {
+	return r.favorites
+}
+
+//Sync ничего не делает, данные в памяти всегда актуальны
+func (r *MemoryFavoriteRepository) Sync() error {
+	return nil
+}
+
+//withoutPayment возвращает новый слайс без payment
+func withoutPayment(payments []*types.Payment, payment *types.Payment) []*types.Payment {
+	for i, pmt := range payments {
+		if pmt == payment {
+			return append(payments[:i:i], payments[i+1:]...)
+		}
+	}
+	return payments
+}
+
+//withoutFavorite возвращает новый слайс без favorite
+func withoutFavorite(favorites []*types.Favorite, favorite *types.Favorite) []*types.Favorite {
+	for i, fav := range favorites {
+		if fav == favorite {
+			return append(favorites[:i:i], favorites[i+1:]...)
+		}
+	}
+	return favorites
+}
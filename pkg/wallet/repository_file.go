@@ -0,0 +1,421 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+//OpenFileRepositories открывает файловые хранилища в dir: accounts.dump, payments.dump
+//и favorites.dump в формате Export, так что каталог можно загрузить и через Import.
+//
+//Хранятся только аккаунты с балансами, платежи и избранное. Журнал не сохраняется:
+//NewService проводит балансы как входящие остатки с ID, построенными из ID аккаунта,
+//поэтому журнал заново открытого сервиса совпадает с прежним. Блокировки и Held
+//живут в сервисе и после открытия начинаются с нуля, их сохраняет Export в holds.dump.
+func OpenFileRepositories(dir string) (Repositories, error) {
+	accounts, err := OpenFileAccountRepository(filepath.Join(dir, "accounts.dump"))
+	if err != nil {
+		return Repositories{}, err
+	}
+	payments, err := OpenFilePaymentRepository(filepath.Join(dir, "payments.dump"))
+	if err != nil {
+		return Repositories{}, err
+	}
+	favorites, err := OpenFileFavoriteRepository(filepath.Join(dir, "favorites.dump"))
+	if err != nil {
+		return Repositories{}, err
+	}
+	return Repositories{Accounts: accounts, Payments: payments, Favorites: favorites}, nil
+}
+
+//FileAccountRepository хранилище аккаунтов в памяти, которое при Sync дописывает
+//в файл строки изменившихся аккаунтов, см. dumpFile
+type FileAccountRepository struct {
+	*MemoryAccountRepository
+	file dumpFile
+}
+
+//OpenFileAccountRepository открывает хранилище аккаунтов, файла может ещё не быть
+func OpenFileAccountRepository(path string) (*FileAccountRepository, error) {
+	r := &FileAccountRepository{MemoryAccountRepository: NewMemoryAccountRepository(), file: dumpFile{path: path}}
+	lines, err := r.file.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		account, err := parseAccount(r.file.path, line.n, line.text)
+		if err != nil {
+			return nil, err
+		}
+		r.Add(account)
+	}
+	return r, nil
+}
+
+func (r *FileAccountRepository) Sync() error {
+	lines := make([]string, 0, len(r.All()))
+	for _, account := range r.All() {
+		lines = append(lines, formatAccount(account))
+	}
+	return r.file.save(lines)
+}
+
+//FilePaymentRepository хранилище платежей, см. FileAccountRepository
+type FilePaymentRepository struct {
+	*MemoryPaymentRepository
+	file dumpFile
+}
+
+//OpenFilePaymentRepository открывает хранилище платежей, файла может ещё не быть
+func OpenFilePaymentRepository(path string) (*FilePaymentRepository, error) {
+	r := &FilePaymentRepository{MemoryPaymentRepository: NewMemoryPaymentRepository(), file: dumpFile{path: path}}
+	lines, err := r.file.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		payment, err := parsePayment(r.file.path, line.n, line.text)
+		if err != nil {
+			return nil, err
+		}
+		r.Add(payment)
+	}
+	return r, nil
+}
+
+func (r *FilePaymentRepository) Sync() error {
+	lines := make([]string, 0, len(r.All()))
+	for _, payment := range r.All() {
+		lines = append(lines, formatPayment(payment))
+	}
+	return r.file.save(lines)
+}
+
+//FileFavoriteRepository хранилище избранных платежей, см. FileAccountRepository
+type FileFavoriteRepository struct {
+	*MemoryFavoriteRepository
+	file dumpFile
+}
+
+//OpenFileFavoriteRepository открывает хранилище избранных, файла может ещё не быть
+func OpenFileFavoriteRepository(path string) (*FileFavoriteRepository, error) {
+	r := &FileFavoriteRepository{MemoryFavoriteRepository: NewMemoryFavoriteRepository(), file: dumpFile{path: path}}
+	lines, err := r.file.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		favorite, err := parseFavorite(r.file.path, line.n, line.text)
+		if err != nil {
+			return nil, err
+		}
+		r.Add(favorite)
+	}
+	return r, nil
+}
+
+func (r *FileFavoriteRepository) Sync() error {
+	lines := make([]string, 0, len(r.All()))
+	for _, favorite := range r.All() {
+		lines = append(lines, formatFavorite(favorite))
+	}
+	return r.file.save(lines)
+}
+
+//dumpFile файл хранилища из строк, первое поле которых - ID сущности.
+//Изменённая сущность дописывается в конец файла новой строкой, при чтении
+//побеждает последняя строка с тем же ID. Sync форматирует все сущности, чтобы
+//найти изменённые, но пишет на диск только их. Когда устаревших строк становится
+//больше, чем актуальных, или сущность удалена, файл переписывается целиком.
+type dumpFile struct {
+	path string
+	//written хэш последней записанной строки каждой сущности по ID
+	written map[string][sha256.Size]byte
+	//lines сколько строк в файле вместе с устаревшими
+	lines int
+}
+
+//dumpLine строка файла хранилища и её номер, n нужен для ошибок
+type dumpLine struct {
+	n    int
+	text string
+}
+
+//load читает непустые строки файла, отсутствующий файл считается пустым.
+//Для каждого ID возвращается последняя строка на месте первой.
+func (f *dumpFile) load() ([]dumpLine, error) {
+	f.written = make(map[string][sha256.Size]byte)
+	f.lines = 0
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []dumpLine{}
+	positions := make(map[string]int)
+	for i, line := range strings.Split(string(data), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		f.lines++
+		key := dumpKey(line)
+		f.written[key] = sha256.Sum256([]byte(line))
+		if position, ok := positions[key]; ok {
+			lines[position] = dumpLine{n: i + 1, text: line}
+			continue
+		}
+		positions[key] = len(lines)
+		lines = append(lines, dumpLine{n: i + 1, text: line})
+	}
+	return lines, nil
+}
+
+//save дописывает изменившиеся строки одной записью либо переписывает файл
+//целиком через временный, чтобы при сбое он не остался записанным наполовину
+func (f *dumpFile) save(lines []string) error {
+	if f.written == nil {
+		f.written = make(map[string][sha256.Size]byte)
+	}
+	written := make(map[string][sha256.Size]byte, len(lines))
+	changed := []byte{}
+	count := 0
+	for _, line := range lines {
+		key := dumpKey(line)
+		sum := sha256.Sum256([]byte(line))
+		written[key] = sum
+		if old, ok := f.written[key]; ok && old == sum {
+			continue
+		}
+		changed = append(changed, line+"\n"...)
+		count++
+	}
+	removed := len(written) < len(f.written)
+	if count == 0 && !removed {
+		return nil
+	}
+
+	if removed || f.lines+count > 2*len(lines) {
+		err := f.rewrite(lines)
+		if err != nil {
+			return err
+		}
+		f.lines = len(lines)
+		f.written = written
+		return nil
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(changed)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	f.lines += count
+	f.written = written
+	return nil
+}
+
+//rewrite записывает файл заново только из актуальных строк
+func (f *dumpFile) rewrite(lines []string) error {
+	data := []byte{}
+	for _, line := range lines {
+		data = append(data, line+"\n"...)
+	}
+	tmp := f.path + ".tmp"
+	err := os.WriteFile(tmp, data, 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+//dumpKey возвращает ID сущности - первое поле строки
+func dumpKey(line string) string {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+//formatAccount записывает аккаунт строкой dump: id;phone;balance;created;updated;overdraftLimit;tier
+func formatAccount(account *types.Account) string {
+	return strconv.FormatInt(account.ID, 10) + ";" +
+		dumpReplacer.Replace(string(account.Phone)) + ";" +
+		strconv.FormatInt(int64(account.Balance), 10) + ";" +
+		formatTime(account.Created) + ";" +
+		formatTime(account.Updated) + ";" +
+		strconv.FormatInt(int64(account.OverdraftLimit), 10) + ";" +
+		dumpReplacer.Replace(string(account.Tier))
+}
+
+//parseAccount читает строку n файла path, записанную formatAccount. Поля после balance необязательны.
+func parseAccount(path string, n int, line string) (*types.Account, error) {
+	fields, err := dumpFields(path, n, line, 3)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	balance, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	created, updated, err := parseTimes(fields, 3)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	var limit int64
+	if len(fields) > 5 {
+		limit, err = strconv.ParseInt(fields[5], 10, 64)
+		if err != nil {
+			return nil, dumpError(path, n, err)
+		}
+	}
+	var tier types.AccountTier
+	if len(fields) > 6 {
+		tier = types.AccountTier(fields[6])
+	}
+
+	return &types.Account{
+		ID:             id,
+		Phone:          types.Phone(fields[1]),
+		Balance:        types.Money(balance),
+		OverdraftLimit: types.Money(limit),
+		Tier:           tier,
+		Created:        created,
+		Updated:        updated,
+	}, nil
+}
+
+//formatPayment записывает платёж строкой dump:
+//id;account;amount;category;status;history;refunded;created;updated;favoriteID;note
+func formatPayment(payment *types.Payment) string {
+	return payment.ID + ";" +
+		strconv.FormatInt(payment.AccountID, 10) + ";" +
+		strconv.FormatInt(int64(payment.Amount), 10) + ";" +
+		dumpReplacer.Replace(string(payment.Category)) + ";" +
+		string(payment.Status) + ";" +
+		formatHistory(payment.History) + ";" +
+		strconv.FormatInt(int64(payment.Refunded), 10) + ";" +
+		formatTime(payment.Created) + ";" +
+		formatTime(payment.Updated) + ";" +
+		payment.FavoriteID + ";" +
+		dumpReplacer.Replace(payment.Note)
+}
+
+//parsePayment читает строку n файла path, записанную formatPayment. Поля после status необязательны.
+func parsePayment(path string, n int, line string) (*types.Payment, error) {
+	fields, err := dumpFields(path, n, line, 5)
+	if err != nil {
+		return nil, err
+	}
+	accountID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	amount, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	var history []types.StatusChange
+	if len(fields) > 5 {
+		history = parseHistory(fields[5])
+	}
+	var refunded int64
+	if len(fields) > 6 {
+		refunded, err = strconv.ParseInt(fields[6], 10, 64)
+		if err != nil {
+			return nil, dumpError(path, n, err)
+		}
+	}
+	created, updated, err := parseTimes(fields, 7)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	var favoriteID, note string
+	if len(fields) > 10 {
+		favoriteID = fields[9]
+		note = fields[10]
+	}
+
+	return &types.Payment{
+		ID:         fields[0],
+		AccountID:  accountID,
+		Amount:     types.Money(amount),
+		Category:   types.PaymentCategory(fields[3]),
+		Status:     types.PaymentStatus(fields[4]),
+		Refunded:   types.Money(refunded),
+		History:    history,
+		FavoriteID: favoriteID,
+		Note:       note,
+		Created:    created,
+		Updated:    updated,
+	}, nil
+}
+
+//formatFavorite записывает избранное строкой dump: id;account;name;amount;category;created;updated;uses
+func formatFavorite(favorite *types.Favorite) string {
+	return favorite.ID + ";" +
+		strconv.FormatInt(favorite.AccountID, 10) + ";" +
+		dumpReplacer.Replace(favorite.Name) + ";" +
+		strconv.FormatInt(int64(favorite.Amount), 10) + ";" +
+		dumpReplacer.Replace(string(favorite.Category)) + ";" +
+		formatTime(favorite.Created) + ";" +
+		formatTime(favorite.Updated) + ";" +
+		strconv.FormatInt(favorite.Uses, 10)
+}
+
+//parseFavorite читает строку n файла path, записанную formatFavorite. Поля после category необязательны.
+func parseFavorite(path string, n int, line string) (*types.Favorite, error) {
+	fields, err := dumpFields(path, n, line, 5)
+	if err != nil {
+		return nil, err
+	}
+	accountID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	amount, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	created, updated, err := parseTimes(fields, 5)
+	if err != nil {
+		return nil, dumpError(path, n, err)
+	}
+	var uses int64
+	if len(fields) > 7 {
+		uses, err = strconv.ParseInt(fields[7], 10, 64)
+		if err != nil {
+			return nil, dumpError(path, n, err)
+		}
+	}
+
+	return &types.Favorite{
+		ID:        fields[0],
+		AccountID: accountID,
+		Name:      fields[2],
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(fields[4]),
+		Uses:      uses,
+		Created:   created,
+		Updated:   updated,
+	}, nil
+}
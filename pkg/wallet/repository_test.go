@@ -0,0 +1,328 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestNewService_fileRepositories(t *testing.T) {
+	dir := t.TempDir()
+	repos, err := OpenFileRepositories(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testService{Service: NewService(repos), clock: newFakeClock()}
+	s.Service.clock = s.clock
+
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.Pay(account.ID, 10_00, "internet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(first.ID, "internet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+
+	repos, err = OpenFileRepositories(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewService(repos)
+
+	got, err := restarted.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, account) {
+		t.Errorf("FindAccountByID(): wrong account returned = %v, want %v", got, account)
+	}
+	gotPayment, err := restarted.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotPayment, payment) {
+		t.Errorf("FindPaymentByID(): wrong payment returned = %v, want %v", gotPayment, payment)
+	}
+	favorites, err := restarted.AccountFavorites(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favorites) != 1 || favorites[0].ID != favorite.ID || favorites[0].Uses != 1 {
+		t.Errorf("AccountFavorites(): wrong favorites returned = %v", favorites)
+	}
+	if err := restarted.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+
+	next, err := restarted.RegisterAccount("+992000000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != account.ID+1 {
+		t.Errorf("RegisterAccount(): ID = %v, want %v", next.ID, account.ID+1)
+	}
+}
+
+func TestOpenFileRepositories_invalid(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "payments.dump"), []byte("p1;1;10;auto;OK\np2;x;10;auto;OK\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenFileRepositories(dir)
+	if !errors.Is(err, ErrInvalidDump) || CodeOf(err) != CodeInvalidDump {
+		t.Errorf("OpenFileRepositories(): must return ErrInvalidDump, returned = %v", err)
+	}
+}
+
+func TestNewService_fileRepositoriesSeparators(t *testing.T) {
+	dir := t.TempDir()
+	repos, err := OpenFileRepositories(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(repos)
+	account, err := s.RegisterAccount("+992000000001;1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Deposit(account.ID, 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 10_00, "auto;fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err = OpenFileRepositories(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewService(repos)
+	got, err := restarted.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Phone != "+992000000001 1" || got.Balance != 90_00 {
+		t.Errorf("FindAccountByID(): wrong account returned = %v", got)
+	}
+	gotPayment, err := restarted.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPayment.Category != "auto fuel" || gotPayment.Amount != 10_00 {
+		t.Errorf("FindPaymentByID(): wrong payment returned = %v", gotPayment)
+	}
+}
+
+func TestNewService_fileRepositoriesLedger(t *testing.T) {
+	dir := t.TempDir()
+	repos, err := OpenFileRepositories(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(repos)
+	account, err := s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Deposit(account.ID, 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := [][]types.Entry{}
+	for i := 0; i < 2; i++ {
+		repos, err = OpenFileRepositories(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restarted := NewService(repos)
+		if err := restarted.VerifyLedger(); err != nil {
+			t.Error(err)
+		}
+		got, err := restarted.AccountEntries(account.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, got)
+	}
+	if len(entries[0]) != 1 || entries[0][0].OperationID != "opening:1" || !reflect.DeepEqual(entries[0], entries[1]) {
+		t.Errorf("AccountEntries(): opening entries must be the same after reopening, returned = %v, %v", entries[0], entries[1])
+	}
+}
+
+func TestFileAccountRepository_Sync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.dump")
+	r, err := OpenFileAccountRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &types.Account{ID: 1, Phone: "+992000000001"}
+	r.Add(first)
+	r.Add(&types.Account{ID: 2, Phone: "+992000000002"})
+
+	lines := func() int {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+	tests := []struct {
+		balance types.Money
+		lines   int
+	}{
+		{0, 2},
+		{10_00, 3},
+		{10_00, 3},
+		{20_00, 4},
+		{30_00, 2},
+	}
+	for i, tt := range tests {
+		first.Balance = tt.balance
+		if err := r.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if got := lines(); got != tt.lines {
+			t.Errorf("Sync() #%v: file has %v lines, want %v", i, got, tt.lines)
+		}
+	}
+
+	r, err = OpenFileAccountRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := r.All()
+	if len(accounts) != 2 || accounts[0].ID != 1 || accounts[0].Balance != 30_00 {
+		t.Errorf("OpenFileAccountRepository(): wrong accounts = %v", accounts)
+	}
+}
+
+func TestFileFavoriteRepository_Sync_remove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "favorites.dump")
+	r, err := OpenFileFavoriteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite := &types.Favorite{ID: "f1", AccountID: 1, Name: "auto", Amount: 10_00, Category: "auto"}
+	r.Add(favorite)
+	r.Add(&types.Favorite{ID: "f2", AccountID: 1, Name: "fuel", Amount: 20_00, Category: "fuel"})
+	if err := r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	r.Remove(favorite)
+	if err := r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = OpenFileFavoriteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorites := r.All()
+	if len(favorites) != 1 || favorites[0].ID != "f2" {
+		t.Errorf("OpenFileFavoriteRepository(): removed favorite must not be loaded, returned = %v", favorites)
+	}
+}
+
+//countingAccounts хранилище аккаунтов, считающее вызовы Sync
+type countingAccounts struct {
+	*MemoryAccountRepository
+	syncs int
+	err   error
+}
+
+func (r *countingAccounts) Sync() error {
+	r.syncs++
+	return r.err
+}
+
+func TestNewService_customRepository(t *testing.T) {
+	accounts := &countingAccounts{MemoryAccountRepository: NewMemoryAccountRepository(
+		&types.Account{ID: 7, Phone: "+992000000007", Balance: 50_00},
+	)}
+	s := NewService(Repositories{Accounts: accounts})
+
+	account, err := s.FindAccountByPhone("+992000000007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(account.ID, 10_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 40_00 || accounts.syncs != 1 {
+		t.Errorf("Pay(): balance = %v, syncs = %v", account.Balance, accounts.syncs)
+	}
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_syncOnlyChanged(t *testing.T) {
+	accounts := &countingAccounts{MemoryAccountRepository: NewMemoryAccountRepository()}
+	s := NewService(Repositories{Accounts: accounts})
+
+	s.SetHoldTTL(time.Hour)
+	err := s.SetFeeRules(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accounts.syncs != 0 {
+		t.Errorf("settings: syncs = %v, want 0", accounts.syncs)
+	}
+
+	_, err = s.RegisterAccount("+992000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accounts.syncs != 1 {
+		t.Errorf("RegisterAccount(): syncs = %v, want 1", accounts.syncs)
+	}
+}
+
+func TestService_syncError(t *testing.T) {
+	accounts := &countingAccounts{MemoryAccountRepository: NewMemoryAccountRepository(
+		&types.Account{ID: 7, Phone: "+992000000007", Balance: 50_00},
+	)}
+	s := NewService(Repositories{Accounts: accounts})
+	diskFull := errors.New("disk full")
+	accounts.err = diskFull
+
+	_, err := s.Pay(7, 10_00, "auto")
+	if !errors.Is(err, ErrRepositorySync) || !errors.Is(err, diskFull) || CodeOf(err) != CodeRepositorySync {
+		t.Fatalf("Pay(): err = %v, want ErrRepositorySync", err)
+	}
+
+	accounts.err = nil
+	err = s.Deposit(7, 5_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accounts.syncs != 2 {
+		t.Errorf("Deposit(): syncs = %v, want 2", accounts.syncs)
+	}
+}
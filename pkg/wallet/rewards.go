@@ -0,0 +1,329 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrInvalidRewardRule = errors.New("invalid reward rule")
+var ErrNoRewards = errors.New("no rewards to redeem")
+
+//DefaultPointValue сколько по умолчанию стоит один балл при выводе на счёт
+const DefaultPointValue types.Money = 1
+
+//RewardsSource источник пополнений, которыми выводятся вознаграждения
+const RewardsSource = "rewards"
+
+//rewards правила и начисления вознаграждений
+type rewards struct {
+	rules      []types.RewardRule
+	pointValue types.Money
+	rewards    []*types.Reward
+	byAccount  map[int64][]*types.Reward
+	byPayment  map[string][]*types.Reward
+}
+
+//SetRewardRules заменяет правила начисления вознаграждений.
+//За платёж начисляется по всем подходящим правилам.
+func (s *Service) SetRewardRules(rules []types.RewardRule, opts ...CallOption) (err error) {
+	for i, rule := range rules {
+		if rule.Kind != types.RewardCashback && rule.Kind != types.RewardPoints {
+			return ruleError(ErrInvalidRewardRule, "reward_rules", i, "unknown kind "+string(rule.Kind))
+		}
+		if rule.Rate < 0 || rule.Max < 0 {
+			return ruleError(ErrInvalidRewardRule, "reward_rules", i, "negative value")
+		}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	resolved := make([]types.RewardRule, len(rules))
+	copy(resolved, rules)
+	for i := range resolved {
+		if resolved[i].Category == "" {
+			continue
+		}
+		category, err := s.resolveCategory(resolved[i].Category)
+		if err != nil {
+			return err
+		}
+		resolved[i].Category = category
+	}
+	s.rewards.rules = resolved
+	s.record("SET_REWARD_RULES", "reward_rules", s.rewards.rules)
+	return nil
+}
+
+//SetPointValue задаёт, сколько стоит один балл при выводе на счёт
+func (s *Service) SetPointValue(value types.Money, opts ...CallOption) (err error) {
+	if value <= 0 {
+		return &Error{Err: ErrAmmountMustBePositive, ID: "point_value", Amount: value}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	s.rewards.pointValue = value
+	s.record("SET_POINT_VALUE", "point_value", value)
+	return nil
+}
+
+func (s *Service) pointValue() types.Money {
+	if s.rewards.pointValue <= 0 {
+		return DefaultPointValue
+	}
+	return s.rewards.pointValue
+}
+
+//RewardBalance возвращает остаток вознаграждений аккаунта. Остаток может быть
+//отрицательным, если платёж отменили после вывода вознаграждения за него.
+func (s *Service) RewardBalance(accountID int64) (types.RewardBalance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return types.RewardBalance{}, err
+	}
+	return s.rewardBalance(accountID), nil
+}
+
+//AccountRewards возвращает начисления и списания вознаграждений аккаунта
+func (s *Service) AccountRewards(accountID int64) ([]types.Reward, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	rewards := []types.Reward{}
+	for _, reward := range s.rewards.byAccount[accountID] {
+		rewards = append(rewards, *reward)
+	}
+	return rewards, nil
+}
+
+//RedeemRewards выводит весь положительный остаток кэшбэка и баллов на счёт
+//одним пополнением с источником RewardsSource
+func (s *Service) RedeemRewards(accountID int64, opts ...CallOption) (_ *types.Deposit, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	_, err = s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := s.rewardBalance(accountID)
+	if balance.Cashback < 0 {
+		balance.Cashback = 0
+	}
+	if balance.Points < 0 {
+		balance.Points = 0
+	}
+	amount := balance.Cashback + types.Money(balance.Points)*s.pointValue()
+	if amount <= 0 {
+		return nil, &Error{Err: ErrNoRewards, AccountID: accountID}
+	}
+
+	deposit, err := s.deposit(accountID, amount, RewardsSource)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Cashback > 0 {
+		s.insertReward(&types.Reward{
+			ID:        uuid.New().String(),
+			AccountID: accountID,
+			Kind:      types.RewardCashback,
+			Amount:    -int64(balance.Cashback),
+			DepositID: deposit.ID,
+			Created:   deposit.Created,
+		})
+	}
+	if balance.Points > 0 {
+		s.insertReward(&types.Reward{
+			ID:        uuid.New().String(),
+			AccountID: accountID,
+			Kind:      types.RewardPoints,
+			Amount:    -balance.Points,
+			DepositID: deposit.ID,
+			Created:   deposit.Created,
+		})
+	}
+	return copyDeposit(deposit, nil)
+}
+
+func (s *Service) rewardBalance(accountID int64) types.RewardBalance {
+	balance := types.RewardBalance{}
+	for _, reward := range s.rewards.byAccount[accountID] {
+		switch reward.Kind {
+		case types.RewardCashback:
+			balance.Cashback += types.Money(reward.Amount - reward.Reversed)
+		case types.RewardPoints:
+			balance.Points += reward.Amount - reward.Reversed
+		}
+	}
+	return balance
+}
+
+//accrueRewards начисляет вознаграждения за проведённый платёж, по одному на каждый вид
+func (s *Service) accrueRewards(payment *types.Payment) {
+	amounts := make(map[types.RewardKind]int64)
+	for _, rule := range s.rewards.rules {
+		if !s.categoryMatches(rule.Category, payment.Category) {
+			continue
+		}
+		amount := int64(payment.Amount) * rule.Rate / 10_000
+		if rule.Max > 0 && amount > rule.Max {
+			amount = rule.Max
+		}
+		amounts[rule.Kind] += amount
+	}
+
+	for _, kind := range []types.RewardKind{types.RewardCashback, types.RewardPoints} {
+		if amounts[kind] <= 0 {
+			continue
+		}
+		s.insertReward(&types.Reward{
+			ID:        uuid.New().String(),
+			AccountID: payment.AccountID,
+			Kind:      kind,
+			Amount:    amounts[kind],
+			PaymentID: payment.ID,
+			Created:   payment.Updated,
+		})
+	}
+}
+
+//reverseRewards отменяет начисления за платёж так, чтобы всего было отменено
+//столько же процентов начисления, сколько refunded составляет от суммы платежа
+func (s *Service) reverseRewards(payment *types.Payment, refunded types.Money) {
+	for _, reward := range s.rewards.byPayment[payment.ID] {
+		total := reward.Amount
+		if refunded < payment.Amount {
+			total = reward.Amount * int64(refunded) / int64(payment.Amount)
+		}
+		if total > reward.Reversed {
+			reward.Reversed = total
+		}
+	}
+}
+
+//insertReward добавляет начисление в хранилище
+func (s *Service) insertReward(reward *types.Reward) {
+	if s.rewards.byAccount == nil {
+		s.rewards.byAccount = make(map[int64][]*types.Reward)
+		s.rewards.byPayment = make(map[string][]*types.Reward)
+	}
+	s.rewards.rewards = append(s.rewards.rewards, reward)
+	s.rewards.byAccount[reward.AccountID] = append(s.rewards.byAccount[reward.AccountID], reward)
+	if reward.PaymentID != "" {
+		s.rewards.byPayment[reward.PaymentID] = append(s.rewards.byPayment[reward.PaymentID], reward)
+	}
+}
+
+//exportRewards записывает вознаграждения в rewards.dump
+func (s *Service) exportRewards(dir string) error {
+	if len(s.rewards.rewards) == 0 {
+		return nil
+	}
+
+	rewData := make([]byte, 0)
+
+	for _, reward := range s.rewards.rewards {
+		str := reward.ID + (";") +
+			strconv.FormatInt(reward.AccountID, 10) + (";") +
+			string(reward.Kind) + (";") +
+			strconv.FormatInt(reward.Amount, 10) + (";") +
+			reward.PaymentID + (";") +
+			reward.DepositID + (";") +
+			strconv.FormatInt(reward.Reversed, 10) + (";") +
+			formatTime(reward.Created) + ("\n")
+
+		rewData = append(rewData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/rewards.dump", rewData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importRewards читает вознаграждения из rewards.dump
+func (s *Service) importRewards(dir string) error {
+	path := dir + "/rewards.dump"
+	rewFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	known := make(map[string]*types.Reward, len(s.rewards.rewards))
+	for _, reward := range s.rewards.rewards {
+		known[reward.ID] = reward
+	}
+
+	for i, rewOperation := range strings.Split(string(rewFile), "\n") {
+		if len(rewOperation) == 0 {
+			break
+		}
+		rewStr, err := dumpFields(path, i+1, rewOperation, 8)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := rewStr[0]
+		accountID, err := strconv.ParseInt(rewStr[1], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		kind := types.RewardKind(rewStr[2])
+		amount, err := strconv.ParseInt(rewStr[3], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		paymentID := rewStr[4]
+		depositID := rewStr[5]
+		reversed, err := strconv.ParseInt(rewStr[6], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		created, err := parseTime(rewStr[7])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		rewFind, ok := known[id]
+		if ok {
+			rewFind.Amount = amount
+			rewFind.Reversed = reversed
+			continue
+		}
+		s.insertReward(&types.Reward{
+			ID:        id,
+			AccountID: accountID,
+			Kind:      kind,
+			Amount:    amount,
+			PaymentID: paymentID,
+			DepositID: depositID,
+			Reversed:  reversed,
+			Created:   created,
+		})
+	}
+	return nil
+}
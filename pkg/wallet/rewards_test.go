@@ -0,0 +1,200 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var testRewardRules = []types.RewardRule{
+	{Category: "food", Kind: types.RewardCashback, Rate: 500},
+	{Kind: types.RewardPoints, Rate: 100, Max: 50},
+}
+
+func TestService_rewards_accruedOnComplete(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetRewardRules(testRewardRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	food, err := s.Pay(account.ID, 200_00, "food")
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := s.RewardBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != (types.RewardBalance{}) {
+		t.Errorf("Pay(): rewards must wait for completion, balance = %v", balance)
+	}
+
+	err = s.Complete(food.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auto, err := s.Pay(account.ID, 30_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Complete(auto.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err = s.RewardBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Cashback != 10_00 || balance.Points != 50+30 {
+		t.Errorf("RewardBalance(): wrong balance = %v", balance)
+	}
+	rewards, err := s.AccountRewards(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewards) != 3 || rewards[0].PaymentID != food.ID || rewards[0].Kind != types.RewardCashback {
+		t.Errorf("AccountRewards(): wrong rewards = %v", rewards)
+	}
+
+	err = s.SetRewardRules([]types.RewardRule{{Kind: "MILES", Rate: 1}})
+	if !errors.Is(err, ErrInvalidRewardRule) {
+		t.Errorf("SetRewardRules(): must return ErrInvalidRewardRule, returned = %v", err)
+	}
+}
+
+func TestService_rewards_reversed(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetRewardRules(testRewardRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejected, err := s.Pay(account.ID, 200_00, "food")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Complete(rejected.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refunded, err := s.Pay(account.ID, 100_00, "food")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Complete(refunded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Reject(rejected.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Refund(refunded.ID, 40_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := s.RewardBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Cashback != 3_00 || balance.Points != 30 {
+		t.Errorf("RewardBalance(): wrong balance after reversal = %v", balance)
+	}
+}
+
+func TestService_RedeemRewards(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 10_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetRewardRules(testRewardRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetPointValue(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.RedeemRewards(account.ID)
+	if !errors.Is(err, ErrNoRewards) {
+		t.Errorf("RedeemRewards(): must return ErrNoRewards, returned = %v", err)
+	}
+
+	payment, err := s.Pay(account.ID, 200_00, "food")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Complete(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance := s.account(t, account.ID).Balance
+
+	deposit, err := s.RedeemRewards(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deposit.Amount != 10_00+50*10 || deposit.Source != RewardsSource {
+		t.Errorf("RedeemRewards(): wrong deposit = %v", deposit)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != balance+deposit.Amount {
+		t.Errorf("RedeemRewards(): wrong account balance = %v", account.Balance)
+	}
+	rewards, err := s.RewardBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewards != (types.RewardBalance{}) {
+		t.Errorf("RedeemRewards(): rewards must be spent, balance = %v", rewards)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewards, err = s.RewardBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewards.Cashback != -10_00 || rewards.Points != -50 {
+		t.Errorf("Reject(): redeemed rewards must be owed, balance = %v", rewards)
+	}
+	_, err = s.RedeemRewards(account.ID)
+	if !errors.Is(err, ErrNoRewards) {
+		t.Errorf("RedeemRewards(): must return ErrNoRewards, returned = %v", err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	err = imported.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	importedRewards, err := imported.RewardBalance(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if importedRewards != rewards {
+		t.Errorf("Import(): rewards = %v, want %v", importedRewards, rewards)
+	}
+}
@@ -0,0 +1,384 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+var ErrInvalidSchedule = errors.New("invalid schedule")
+var ErrScheduleCancelled = errors.New("schedule cancelled")
+
+//scheduler хранилище регулярных платежей
+type scheduler struct {
+	schedules []*types.Schedule
+	byID      map[string]*types.Schedule
+	runs      map[string][]types.ScheduleRun
+}
+
+//ScheduleFavorite создаёт регулярный платёж по избранному.
+//day - день недели (0 - воскресенье) для WEEKLY или день месяца (1-31) для MONTHLY,
+//для DAILY игнорируется. Платёж выполняется в начале дня по часам сервиса.
+func (s *Service) ScheduleFavorite(favoriteID string, period types.SchedulePeriod, day int, opts ...CallOption) (_ *types.Schedule, err error) {
+	valid := true
+	switch period {
+	case types.ScheduleDaily:
+		day = 0
+	case types.ScheduleWeekly:
+		valid = day >= 0 && day <= 6
+	case types.ScheduleMonthly:
+		valid = day >= 1 && day <= 31
+	default:
+		valid = false
+	}
+	if !valid {
+		return nil, &Error{Err: ErrInvalidSchedule, ID: favoriteID, Cause: errors.New("period " + string(period) + ", day " + strconv.Itoa(day))}
+	}
+
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	favorite, err := s.getFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	schedule := &types.Schedule{
+		ID:         uuid.New().String(),
+		FavoriteID: favorite.ID,
+		AccountID:  favorite.AccountID,
+		Period:     period,
+		Day:        day,
+		Status:     types.ScheduleStatusActive,
+		Created:    now,
+		Updated:    now,
+	}
+	schedule.NextRun = nextRun(schedule, now)
+
+	s.insertSchedule(schedule)
+	s.record("SCHEDULE_CREATED", "schedule:"+schedule.ID, *schedule)
+
+	return copySchedule(schedule, nil)
+}
+
+//insertSchedule добавляет регулярный платёж в хранилище
+func (s *Service) insertSchedule(schedule *types.Schedule) {
+	if s.scheduler.byID == nil {
+		s.scheduler.byID = make(map[string]*types.Schedule)
+		s.scheduler.runs = make(map[string][]types.ScheduleRun)
+	}
+	s.scheduler.schedules = append(s.scheduler.schedules, schedule)
+	s.scheduler.byID[schedule.ID] = schedule
+}
+
+//FindScheduleByID поиск регулярного платежа по ID
+func (s *Service) FindScheduleByID(scheduleID string) (*types.Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return copySchedule(s.findScheduleByID(scheduleID))
+}
+
+func (s *Service) findScheduleByID(scheduleID string) (*types.Schedule, error) {
+	schedule, ok := s.scheduler.byID[scheduleID]
+	if !ok {
+		return nil, &Error{Err: ErrScheduleNotFound, ID: scheduleID}
+	}
+	return schedule, nil
+}
+
+//PauseSchedule приостанавливает регулярный платёж
+func (s *Service) PauseSchedule(scheduleID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	schedule, err := s.findScheduleByID(scheduleID)
+	if err != nil {
+		return err
+	}
+	if schedule.Status == types.ScheduleStatusCancelled {
+		return &Error{Err: ErrScheduleCancelled, ID: schedule.ID}
+	}
+
+	schedule.Status = types.ScheduleStatusPaused
+	schedule.Updated = s.now()
+	s.record("SCHEDULE_PAUSED", "schedule:"+schedule.ID, *schedule)
+	return nil
+}
+
+//ResumeSchedule возобновляет регулярный платёж. Пропущенные за время паузы
+//платежи не выполняются, следующий считается от текущего момента.
+func (s *Service) ResumeSchedule(scheduleID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	schedule, err := s.findScheduleByID(scheduleID)
+	if err != nil {
+		return err
+	}
+	if schedule.Status == types.ScheduleStatusCancelled {
+		return &Error{Err: ErrScheduleCancelled, ID: schedule.ID}
+	}
+	if schedule.Status == types.ScheduleStatusActive {
+		return nil
+	}
+
+	now := s.now()
+	schedule.Status = types.ScheduleStatusActive
+	schedule.NextRun = nextRun(schedule, now)
+	schedule.Updated = now
+	s.record("SCHEDULE_RESUMED", "schedule:"+schedule.ID, *schedule)
+	return nil
+}
+
+//CancelSchedule отменяет регулярный платёж без возможности возобновления
+func (s *Service) CancelSchedule(scheduleID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	schedule, err := s.findScheduleByID(scheduleID)
+	if err != nil {
+		return err
+	}
+
+	schedule.Status = types.ScheduleStatusCancelled
+	schedule.Updated = s.now()
+	s.record("SCHEDULE_CANCELLED", "schedule:"+schedule.ID, *schedule)
+	return nil
+}
+
+//RunDueSchedules выполняет все активные регулярные платежи, время которых наступило.
+//Каждый платёж выполняется не более одного раза за вызов, даже если пропущено несколько периодов.
+//Ошибки отдельных платежей записываются в ScheduleRun.Error, а ошибка сохранения изменений
+//возвращается вместе с выполненными запусками.
+func (s *Service) RunDueSchedules(opts ...CallOption) (_ []types.ScheduleRun, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	now := s.now()
+	runs := []types.ScheduleRun{}
+	for _, schedule := range s.scheduler.schedules {
+		if schedule.Status != types.ScheduleStatusActive || schedule.NextRun.After(now) {
+			continue
+		}
+
+		run := types.ScheduleRun{
+			ScheduleID: schedule.ID,
+			At:         now,
+		}
+		payment, err := s.payFromFavorite(schedule.FavoriteID)
+		if err != nil {
+			run.Error = err.Error()
+		} else {
+			run.PaymentID = payment.ID
+		}
+
+		schedule.NextRun = nextRun(schedule, now)
+		schedule.Updated = now
+		s.scheduler.runs[schedule.ID] = append(s.scheduler.runs[schedule.ID], run)
+		s.record("SCHEDULE_RUN", "schedule:"+schedule.ID, *schedule)
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+//ScheduleRuns возвращает историю запусков регулярного платежа
+func (s *Service) ScheduleRuns(scheduleID string) ([]types.ScheduleRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findScheduleByID(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]types.ScheduleRun, len(s.scheduler.runs[scheduleID]))
+	copy(runs, s.scheduler.runs[scheduleID])
+	return runs, nil
+}
+
+//nextRun возвращает начало ближайшего подходящего дня строго после after
+func nextRun(schedule *types.Schedule, after time.Time) time.Time {
+	year, month, day := after.Date()
+	next := time.Date(year, month, day+1, 0, 0, 0, 0, after.Location())
+
+	switch schedule.Period {
+	case types.ScheduleWeekly:
+		days := (schedule.Day - int(next.Weekday()) + 7) % 7
+		next = next.AddDate(0, 0, days)
+	case types.ScheduleMonthly:
+		year, month, _ := next.Date()
+		for {
+			candidate := monthDay(year, month, schedule.Day, after.Location())
+			if !candidate.Before(next) {
+				return candidate
+			}
+			month++
+		}
+	}
+	return next
+}
+
+//monthDay возвращает день day месяца, для коротких месяцев - последний день
+func monthDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+//exportSchedules записывает регулярные платежи в schedules.dump,
+//а историю их запусков в schedule_runs.dump
+func (s *Service) exportSchedules(dir string) error {
+	if len(s.scheduler.schedules) == 0 {
+		return nil
+	}
+
+	schData := make([]byte, 0)
+	runData := make([]byte, 0)
+
+	for _, schedule := range s.scheduler.schedules {
+		str := schedule.ID + (";") +
+			schedule.FavoriteID + (";") +
+			strconv.FormatInt(schedule.AccountID, 10) + (";") +
+			string(schedule.Period) + (";") +
+			strconv.Itoa(schedule.Day) + (";") +
+			string(schedule.Status) + (";") +
+			formatTime(schedule.NextRun) + (";") +
+			formatTime(schedule.Created) + (";") +
+			formatTime(schedule.Updated) + ("\n")
+
+		schData = append(schData, []byte(str)...)
+
+		for _, run := range s.scheduler.runs[schedule.ID] {
+			str := run.ScheduleID + (";") +
+				formatTime(run.At) + (";") +
+				run.PaymentID + (";") +
+				dumpReplacer.Replace(run.Error) + ("\n")
+
+			runData = append(runData, []byte(str)...)
+		}
+	}
+	err := os.WriteFile(dir+"/schedules.dump", schData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = os.WriteFile(dir+"/schedule_runs.dump", runData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importSchedules читает регулярные платежи из schedules.dump и историю запусков
+//из schedule_runs.dump. История загруженного платежа заменяет уже известную.
+func (s *Service) importSchedules(dir string) error {
+	path := dir + "/schedules.dump"
+	schFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, schOperation := range strings.Split(string(schFile), "\n") {
+		if len(schOperation) == 0 {
+			break
+		}
+		schStr, err := dumpFields(path, i+1, schOperation, 9)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := schStr[0]
+		accountID, err := strconv.ParseInt(schStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		day, err := strconv.Atoi(schStr[4])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		nextRun, err := parseTime(schStr[6])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		created, updated, err := parseTimes(schStr, 7)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		schedule, _ := s.findScheduleByID(id)
+		if schedule == nil {
+			schedule = &types.Schedule{ID: id}
+			s.insertSchedule(schedule)
+		}
+		schedule.FavoriteID = schStr[1]
+		schedule.AccountID = accountID
+		schedule.Period = types.SchedulePeriod(schStr[3])
+		schedule.Day = day
+		schedule.Status = types.ScheduleStatus(schStr[5])
+		schedule.NextRun = nextRun
+		schedule.Created = created
+		schedule.Updated = updated
+	}
+
+	return s.importScheduleRuns(dir)
+}
+
+//importScheduleRuns читает историю запусков из schedule_runs.dump
+func (s *Service) importScheduleRuns(dir string) error {
+	path := dir + "/schedule_runs.dump"
+	runFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	runs := make(map[string][]types.ScheduleRun)
+	for i, runOperation := range strings.Split(string(runFile), "\n") {
+		if len(runOperation) == 0 {
+			break
+		}
+		runStr, err := dumpFields(path, i+1, runOperation, 4)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		at, err := parseTime(runStr[1])
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		runs[runStr[0]] = append(runs[runStr[0]], types.ScheduleRun{
+			ScheduleID: runStr[0],
+			At:         at,
+			PaymentID:  runStr[2],
+			Error:      runStr[3],
+		})
+	}
+
+	for scheduleID, scheduleRuns := range runs {
+		if _, err := s.findScheduleByID(scheduleID); err != nil {
+			continue
+		}
+		s.scheduler.runs[scheduleID] = scheduleRuns
+	}
+	return nil
+}
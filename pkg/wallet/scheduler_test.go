@@ -0,0 +1,263 @@
+package wallet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestNextRun(t *testing.T) {
+	after := time.Date(2021, time.January, 30, 15, 0, 0, 0, time.UTC) // суббота
+	tests := []struct {
+		period types.SchedulePeriod
+		day    int
+		want   time.Time
+	}{
+		{period: types.ScheduleDaily, want: time.Date(2021, time.January, 31, 0, 0, 0, 0, time.UTC)},
+		{period: types.ScheduleWeekly, day: int(time.Monday), want: time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{period: types.ScheduleWeekly, day: int(time.Saturday), want: time.Date(2021, time.February, 6, 0, 0, 0, 0, time.UTC)},
+		{period: types.ScheduleMonthly, day: 31, want: time.Date(2021, time.January, 31, 0, 0, 0, 0, time.UTC)},
+		{period: types.ScheduleMonthly, day: 30, want: time.Date(2021, time.February, 28, 0, 0, 0, 0, time.UTC)},
+		{period: types.ScheduleMonthly, day: 5, want: time.Date(2021, time.February, 5, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got := nextRun(&types.Schedule{Period: tt.period, Day: tt.day}, after)
+		if !got.Equal(tt.want) {
+			t.Errorf("nextRun(%v, %v): result_we_got %v, result_we_want %v", tt.period, tt.day, got, tt.want)
+		}
+	}
+
+	after = time.Date(2021, time.December, 20, 0, 0, 0, 0, time.UTC)
+	got := nextRun(&types.Schedule{Period: types.ScheduleMonthly, Day: 10}, after)
+	if want := time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("nextRun(): result_we_got %v, result_we_want %v", got, want)
+	}
+}
+
+func TestService_RunDueSchedules(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := s.ScheduleFavorite(favorite.ID, types.ScheduleDaily, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if runs, err := s.RunDueSchedules(); err != nil || len(runs) != 0 {
+		t.Errorf("RunDueSchedules(): nothing must run before NextRun = %v, err = %v", runs, err)
+	}
+
+	balance := account.Balance
+	s.clock.Add(24 * time.Hour)
+	runs, err := s.RunDueSchedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].PaymentID == "" || runs[0].Error != "" {
+		t.Fatalf("RunDueSchedules(): wrong runs = %v", runs)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != balance-favorite.Amount {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, balance-favorite.Amount)
+	}
+	if runs, err := s.RunDueSchedules(); err != nil || len(runs) != 0 {
+		t.Errorf("RunDueSchedules(): schedule must run once a day = %v, err = %v", runs, err)
+	}
+
+	account = s.account(t, account.ID)
+	if _, err := s.Pay(account.ID, account.Balance, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(24 * time.Hour)
+	runs, err = s.RunDueSchedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].PaymentID != "" || !strings.HasPrefix(runs[0].Error, ErrNotEnoughBalance.Error()) {
+		t.Fatalf("RunDueSchedules(): wrong runs = %v", runs)
+	}
+
+	history, err := s.ScheduleRuns(schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", len(history), 2)
+	}
+}
+
+func TestService_RunDueSchedules_syncError(t *testing.T) {
+	accounts := &countingAccounts{MemoryAccountRepository: NewMemoryAccountRepository()}
+	clock := newFakeClock()
+	s := &testService{Service: NewService(Repositories{Accounts: accounts}), clock: clock}
+	s.SetClock(clock)
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ScheduleFavorite(favorite.ID, types.ScheduleDaily, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts.err = errors.New("disk full")
+	s.clock.Add(24 * time.Hour)
+	runs, err := s.RunDueSchedules()
+	if !errors.Is(err, ErrRepositorySync) {
+		t.Errorf("RunDueSchedules(): must return ErrRepositorySync, returned = %v", err)
+	}
+	if len(runs) != 1 || runs[0].PaymentID == "" {
+		t.Errorf("RunDueSchedules(): runs must be returned with the error = %v", runs)
+	}
+}
+
+func TestService_PauseResumeCancelSchedule(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := s.ScheduleFavorite(favorite.ID, types.ScheduleWeekly, int(s.clock.Now().Weekday()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PauseSchedule(schedule.ID); err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(8 * 24 * time.Hour)
+	if runs, err := s.RunDueSchedules(); err != nil || len(runs) != 0 {
+		t.Errorf("RunDueSchedules(): paused schedule must not run = %v, err = %v", runs, err)
+	}
+
+	if err := s.ResumeSchedule(schedule.ID); err != nil {
+		t.Fatal(err)
+	}
+	if runs, err := s.RunDueSchedules(); err != nil || len(runs) != 0 {
+		t.Errorf("RunDueSchedules(): payments missed during pause must not run = %v, err = %v", runs, err)
+	}
+	s.clock.Add(7 * 24 * time.Hour)
+	if runs, err := s.RunDueSchedules(); err != nil || len(runs) != 1 || runs[0].Error != "" {
+		t.Errorf("RunDueSchedules(): resumed schedule must run = %v, err = %v", runs, err)
+	}
+
+	if err := s.CancelSchedule(schedule.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResumeSchedule(schedule.ID); !errors.Is(err, ErrScheduleCancelled) {
+		t.Errorf("ResumeSchedule(): must return ErrScheduleCancelled, returned = %v", err)
+	}
+	s.clock.Add(7 * 24 * time.Hour)
+	if runs, err := s.RunDueSchedules(); err != nil || len(runs) != 0 {
+		t.Errorf("RunDueSchedules(): cancelled schedule must not run = %v, err = %v", runs, err)
+	}
+}
+
+func TestService_ScheduleFavorite_invalid(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ScheduleFavorite(favorite.ID, types.ScheduleMonthly, 0); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("ScheduleFavorite(): must return ErrInvalidSchedule, returned = %v", err)
+	}
+	if _, err := s.ScheduleFavorite(favorite.ID, types.ScheduleWeekly, 7); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("ScheduleFavorite(): must return ErrInvalidSchedule, returned = %v", err)
+	}
+	if _, err := s.ScheduleFavorite(favorite.ID, "YEARLY", 1); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("ScheduleFavorite(): must return ErrInvalidSchedule, returned = %v", err)
+	}
+	if _, err := s.ScheduleFavorite("unknown", types.ScheduleDaily, 0); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("ScheduleFavorite(): must return ErrFavoriteNotFound, returned = %v", err)
+	}
+	if err := s.PauseSchedule("unknown"); !errors.Is(err, ErrScheduleNotFound) {
+		t.Errorf("PauseSchedule(): must return ErrScheduleNotFound, returned = %v", err)
+	}
+}
+
+func TestService_Export_schedules(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := s.ScheduleFavorite(favorite.ID, types.ScheduleDaily, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paused, err := s.ScheduleFavorite(favorite.ID, types.ScheduleMonthly, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PauseSchedule(paused.ID); err != nil {
+		t.Fatal(err)
+	}
+	s.clock.Add(24 * time.Hour)
+	if runs, err := s.RunDueSchedules(); err != nil || len(runs) != 1 {
+		t.Fatalf("RunDueSchedules(): wrong runs = %v, err = %v", runs, err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	imported.clock.Add(24 * time.Hour)
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{schedule.ID, paused.ID} {
+		want, err := s.FindScheduleByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := imported.FindScheduleByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.FavoriteID != want.FavoriteID || got.AccountID != account.ID || got.Period != want.Period ||
+			got.Day != want.Day || got.Status != want.Status || !got.NextRun.Equal(want.NextRun) || !got.Created.Equal(want.Created) {
+			t.Errorf("FindScheduleByID(): wrong schedule returned = %v, want %v", got, want)
+		}
+	}
+	history, err := imported.ScheduleRuns(schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].PaymentID == "" {
+		t.Errorf("ScheduleRuns(): wrong history after import = %v", history)
+	}
+
+	imported.clock.Add(24 * time.Hour)
+	if runs, err := imported.RunDueSchedules(); err != nil || len(runs) != 1 || runs[0].ScheduleID != schedule.ID {
+		t.Errorf("RunDueSchedules(): imported schedule must run, runs = %v, err = %v", runs, err)
+	}
+}
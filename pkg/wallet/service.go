@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"context"
 	"errors"
 	"io"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/rgsgit/wallet/pkg/types"
@@ -21,92 +23,138 @@ var ErrNotEnoughBalance = errors.New("not enough balance")
 var ErrPaymentNotFound = errors.New("payment not found")
 var ErrFavoriteNotFound = errors.New("favorite payment not found")
 
+//Service кошелёк. Все методы безопасны для конкурентного использования.
 type Service struct {
+	mu            sync.RWMutex
 	nextAccountID int64
-	accounts      []*types.Account
-	payments      []*types.Payment
-	favorites     []*types.Favorite
+	//accounts, payments и favorites начальные данные Service, собранного литералом.
+	//При первом обращении они переносятся в хранилища repos (см. Repositories).
+	accounts    []*types.Account
+	payments    []*types.Payment
+	favorites   []*types.Favorite
+	repos       Repositories
+	dirty       dirtyRepositories
+	transfers   []*types.Transfer
+	deposits    []*types.Deposit
+	refunds     []*types.Refund
+	idempotency idempotency
+	clock       Clock
+	holds       holds
+	fees        fees
+	rewards     rewards
+	categories  categories
+	budgets     budgets
+	events      eventBus
+	audit       audit
+	//deletedFavorites удалённые избранные и время удаления, нужны Export/Import
+	deletedFavorites map[string]time.Time
+	overdraftFee     OverdraftFeeFunc
+	limits           map[int64]*types.SpendingLimits
+	scheduler        scheduler
+	ledger           ledger
+	idx              index
 }
 
 //RegisterAccount метод регистрация аккаунта
-func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.Phone == phone {
-			return nil, ErrPhoneRegistred
-		}
+func (s *Service) RegisterAccount(phone types.Phone, opts ...CallOption) (_ *types.Account, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	phone = types.Phone(dumpReplacer.Replace(string(phone)))
+	if _, ok := s.repositories().Accounts.ByPhone(phone); ok {
+		return nil, &Error{Err: ErrPhoneRegistred, ID: string(phone)}
 	}
 
 	s.nextAccountID++
 
+	now := s.now()
 	account := &types.Account{
 		ID:      s.nextAccountID,
 		Phone:   phone,
 		Balance: 0,
+		Created: now,
+		Updated: now,
 	}
 
-	s.accounts = append(s.accounts, account)
+	s.insertAccount(account)
+	s.publish(types.Event{Type: types.EventAccountRegistered, AccountID: account.ID})
 
-	return account, nil
+	return copyAccount(account, nil)
 }
 
 //Deposit метод пополнение счёта
-func (s *Service) Deposit(accountID int64, ammount types.Money) error {
-	if ammount <= 0 {
-		return ErrAmmountMustBePositive
-	}
-
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
-		}
-	}
+func (s *Service) Deposit(accountID int64, ammount types.Money, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
 
-	if account == nil {
-		return ErrAccountNotFound
-	}
+	_, err = s.deposit(accountID, ammount, "")
+	return err
+}
 
-	//зачисление средств
-	account.Balance += ammount
+//Pay метод оплаты
+func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory, opts ...CallOption) (_ *types.Payment, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
 
-	return nil
+	return copyPayment(s.pay(accountID, amount, category))
+}
 
+//pay создаёт платёж, вызывающий должен держать s.mu на запись.
+//Если справочник категорий не пуст, категория приводится к каноничному коду.
+func (s *Service) pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	return s.payWith(&types.Payment{AccountID: accountID, Amount: amount, Category: category})
 }
 
-func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
-	if amount <= 0 {
-		return nil, ErrAmmountMustBePositive
+//payWith создаёт платёж по заготовке payment, см. pay. Кроме аккаунта, суммы и категории
+//в заготовке можно заполнить избранное и примечание: они попадут в событие и журнал вместе с платежом.
+func (s *Service) payWith(payment *types.Payment) (*types.Payment, error) {
+	if payment.Amount <= 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, AccountID: payment.AccountID, Amount: payment.Amount}
 	}
 
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
-		}
+	account, err := s.findAccountByID(payment.AccountID)
+	if err != nil {
+		return nil, err
 	}
 
-	if account == nil {
-		return nil, ErrAccountNotFound
+	payment.Category, err = s.resolveCategory(payment.Category)
+	if err != nil {
+		return nil, err
 	}
 
-	if account.Balance < amount {
-		return nil, ErrNotEnoughBalance
+	fee := s.calculateFee(account, payment.Amount, payment.Category)
+	if !s.canDebit(account, payment.Amount+fee) {
+		return nil, &Error{Err: ErrNotEnoughBalance, AccountID: account.ID, Amount: payment.Amount + fee}
 	}
 
-	account.Balance -= amount
-	paymentID := uuid.New().String()
-	payment := &types.Payment{
-		ID:        paymentID,
-		AccountID: accountID,
-		Amount:    amount,
-		Category:  category,
-		Status:    types.PaymentStatusInProgress,
+	err = s.checkLimits(account.ID, payment.Amount, payment.Category)
+	if err != nil {
+		return nil, err
 	}
 
-	s.payments = append(s.payments, payment)
-	return payment, nil
+	return s.createPayment(payment, fee), nil
+}
+
+//createPayment создаёт платёж по заготовке payment и проводит его вместе с комиссией fee.
+//Баланс и лимиты должен проверить вызывающий.
+func (s *Service) createPayment(payment *types.Payment, fee types.Money) *types.Payment {
+	now := s.now()
+	payment.ID = uuid.New().String()
+	payment.Status = types.PaymentStatusInProgress
+	payment.Created = now
+	payment.Updated = now
+
+	s.insertPayment(payment)
+	s.post(payment.ID, payment.AccountID, LedgerPaymentsAccountID, payment.Amount)
+	s.chargeFee(payment, fee)
+	s.publish(types.Event{
+		Type:      types.EventPaymentCreated,
+		AccountID: payment.AccountID,
+		EntityID:  payment.ID,
+		Amount:    payment.Amount,
+	})
+	s.checkBudgets(payment.AccountID)
+	return payment
 }
 
 /*type Error string
@@ -118,17 +166,16 @@ func (e Error) Error() string {
 
 //FindAccountByID поис аккаунта по ID
 func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
-	var accaount *types.Account
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			accaount = acc
-			break
-		}
-	}
+	return s.accountView(s.findAccountByID(accountID))
+}
 
-	if accaount == nil {
-		return nil, ErrAccountNotFound
+func (s *Service) findAccountByID(accountID int64) (*types.Account, error) {
+	accaount, ok := s.repositories().Accounts.ByID(accountID)
+	if !ok {
+		return nil, &Error{Err: ErrAccountNotFound, AccountID: accountID}
 	}
 
 	return accaount, nil
@@ -136,27 +183,32 @@ func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
 
 //FindPaymentByID поиск плотежа по ID
 func (s *Service) FindPaymentByID(paymetID string) (*types.Payment, error) {
-	var payment *types.Payment
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	for _, pmt := range s.payments {
-		if pmt.ID == paymetID {
-			payment = pmt
-			break
-		}
-	}
+	return copyPayment(s.findPaymentByID(paymetID))
+}
 
-	if payment == nil {
-		return nil, ErrPaymentNotFound
+func (s *Service) findPaymentByID(paymetID string) (*types.Payment, error) {
+	payment, ok := s.repositories().Payments.ByID(paymetID)
+	if !ok {
+		return nil, &Error{Err: ErrPaymentNotFound, ID: paymetID}
 	}
 
 	return payment, nil
-
 }
 
-//Reject метод отмены платежа
-func (s *Service) Reject(paymentID string) error {
-	payment, err := s.FindPaymentByID(paymentID)
+//Reject метод отмены платежа или перевода. Деньги вместе с комиссией возвращаются на счёт,
+//отменить можно платёж в статусе INPROGRESS, OK или PARTIALLY_REFUNDED, повторная отмена ничего не делает.
+func (s *Service) Reject(paymentID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	payment, err := s.findPaymentByID(paymentID)
 	if payment == nil {
+		if transfer, _ := s.findTransferByID(paymentID); transfer != nil {
+			return s.rejectTransfer(transfer)
+		}
 		return err
 	}
 
@@ -164,25 +216,24 @@ func (s *Service) Reject(paymentID string) error {
 		return nil
 	}
 
-	acc, err := s.FindAccountByID(payment.AccountID)
-	if acc == nil {
-		return err
-	}
+	return s.failPayment(payment)
+}
 
-	payment.Status = types.PaymentStatusFail
-	acc.Balance += payment.Amount
+//Repeat повторяет платёж по идинтификатору
+func (s *Service) Repeat(paymentID string, opts ...CallOption) (_ *types.Payment, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
 
-	return nil
+	return copyPayment(s.repeat(paymentID))
 }
 
-//Repeat повторяет платёж по идинтификатору
-func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+func (s *Service) repeat(paymentID string) (*types.Payment, error) {
+	payment, err := s.findPaymentByID(paymentID)
 	if payment == nil {
 		return nil, err
 	}
 
-	newPayment, err := s.Pay(payment.AccountID, payment.Amount, payment.Category)
+	newPayment, err := s.pay(payment.AccountID, payment.Amount, payment.Category)
 	if newPayment == nil {
 		return nil, err
 	}
@@ -191,46 +242,73 @@ func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
 
 }
 
-func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+//FavoritePayment создаёт избранное из платежа
+func (s *Service) FavoritePayment(paymentID string, name string, opts ...CallOption) (_ *types.Favorite, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	name = dumpReplacer.Replace(name)
+	err = s.checkFavoriteName(payment.AccountID, name, "")
 	if err != nil {
-		return nil, ErrPaymentNotFound
+		return nil, err
 	}
+	now := s.now()
 	favorite := &types.Favorite{
 		ID:        uuid.New().String(),
 		AccountID: payment.AccountID,
 		Name:      name,
 		Amount:    payment.Amount,
 		Category:  payment.Category,
+		Created:   now,
+		Updated:   now,
 	}
-	s.favorites = append(s.favorites, favorite)
-	return favorite, nil
+	s.insertFavorite(favorite)
+	s.publish(types.Event{
+		Type:      types.EventFavoriteCreated,
+		AccountID: favorite.AccountID,
+		EntityID:  favorite.ID,
+		Amount:    favorite.Amount,
+	})
+	return copyFavorite(favorite, nil)
 }
 
+//GetFavoriteByID поиск избранного по ID
 func (s *Service) GetFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	for _, fav := range s.favorites {
-		if fav.ID == favoriteID {
-			return fav, nil
-		}
-	}
-	return nil, ErrFavoriteNotFound
+	return copyFavorite(s.getFavoriteByID(favoriteID))
 }
 
-func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
-	favorite, err := s.GetFavoriteByID(favoriteID)
-	if err != nil {
-		return nil, ErrFavoriteNotFound
+func (s *Service) getFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	favorite, ok := s.repositories().Favorites.ByID(favoriteID)
+	if !ok {
+		return nil, &Error{Err: ErrFavoriteNotFound, ID: favoriteID}
 	}
-	payment, err := s.Pay(favorite.AccountID, favorite.Amount, favorite.Category)
-	if err != nil {
-		return nil, err
-	}
-	return payment, nil
+	return favorite, nil
+}
+
+//PayFromFavorite совершает платёж по избранному
+func (s *Service) PayFromFavorite(favoriteID string, opts ...CallOption) (_ *types.Payment, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	return copyPayment(s.payFromFavorite(favoriteID))
+}
+
+func (s *Service) payFromFavorite(favoriteID string) (*types.Payment, error) {
+	return s.payFromFavoriteWith(favoriteID, types.FavoriteOverrides{})
 }
 
 //ExportToFile экспортирует аккаунт в файл
 func (s *Service) ExportToFile(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	file, err := os.Create(path)
 	if err != nil {
 		return err
@@ -241,7 +319,7 @@ func (s *Service) ExportToFile(path string) error {
 		}
 	}()
 
-	for _, account := range s.accounts {
+	for _, account := range s.repositories().Accounts.All() {
 		str := strconv.FormatInt(int64(account.ID), 10) + (";") + (string(account.Phone)) + (";") + (strconv.FormatInt(int64(account.Balance), 10)) + ("|")
 		_, err = file.Write([]byte(str))
 		if err != nil {
@@ -253,7 +331,10 @@ func (s *Service) ExportToFile(path string) error {
 }
 
 //ImportToFile импортирует даные из файла
-func (s *Service) ImportFromFile(path string) error {
+func (s *Service) ImportFromFile(path string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
 	file, err := os.Open(path)
 	if err != nil {
 		log.Print(err)
@@ -292,18 +373,17 @@ func (s *Service) ImportFromFile(path string) error {
 
 		strAcc := strings.Split(operation, ";")
 		log.Println("strAcc:", strAcc)
-		if len(strAcc) <= 0 {
-			return errors.New("Nil")
-		}
-
 		if strAcc[0] == "" {
 			continue
 			//return errors.New("Nil str")
 		}
+		if len(strAcc) < 3 {
+			return &Error{Err: ErrInvalidDump, ID: path}
+		}
 		id, err := strconv.ParseInt(strAcc[0], 10, 64)
 		if err != nil {
 			log.Print(err)
-			return err
+			return &Error{Err: ErrInvalidDump, ID: path, Cause: err}
 		}
 
 		phone := types.Phone(strAcc[1])
@@ -311,24 +391,52 @@ func (s *Service) ImportFromFile(path string) error {
 		balance, err := strconv.ParseInt(strAcc[2], 10, 64)
 		if err != nil {
 			log.Print(err)
-			return err
+			return &Error{Err: ErrInvalidDump, AccountID: id, ID: path, Cause: err}
+		}
+
+		accFind, _ := s.findAccountByID(id)
+		if accFind != nil {
+			s.setAccountPhone(accFind, phone)
+			s.setBalance(accFind, types.Money(balance))
+			continue
 		}
 
 		account := &types.Account{
-			ID:      id,
-			Phone:   phone,
-			Balance: types.Money(balance),
+			ID:    id,
+			Phone: phone,
 		}
 
-		s.accounts = append(s.accounts, account)
+		if id > s.nextAccountID {
+			s.nextAccountID = id
+		}
+		s.insertAccount(account)
+		s.setBalance(account, types.Money(balance))
 		log.Print(account)
 	}
+	s.record("IMPORT", "file:"+path, s.importSummary())
 	return nil
 }
 
-//Export экспортирует все в accounts.dump, payments.dump and favorites.dump
+//Export экспортирует все в accounts.dump, payments.dump, favorites.dump, favorites_deleted.dump,
+//transfers.dump, deposits.dump,
+//refunds.dump, idempotency.dump, limits.dump, holds.dump, fees.dump, rewards.dump,
+//categories.dump, budgets.dump, schedules.dump, schedule_runs.dump and ledger.dump
 func (s *Service) Export(dir string) error {
-	if s.accounts != nil && len(s.accounts) > 0 {
+	return s.ExportContext(context.Background(), dir)
+}
+
+//ExportContext экспортирует данные как Export и прекращает работу при отмене ctx,
+//возвращая ctx.Err(). Уже записанные файлы остаются.
+func (s *Service) ExportContext(ctx context.Context, dir string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repos := s.repositories()
+	if len(repos.Accounts.All()) > 0 {
 		accDir, err := filepath.Abs(dir)
 		if err != nil {
 			log.Print(err)
@@ -337,12 +445,11 @@ func (s *Service) Export(dir string) error {
 
 		accData := make([]byte, 0)
 
-		for _, account := range s.accounts {
-			str := (strconv.FormatInt(int64(account.ID), 10) + (";") +
-				string(account.Phone) + (";") +
-				strconv.FormatInt(int64(account.Balance), 10) + ("\n"))
-
-			accData = append(accData, []byte(str)...)
+		for _, account := range repos.Accounts.All() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			accData = append(accData, []byte(formatAccount(account)+"\n")...)
 		}
 		err = os.WriteFile(accDir+"/accounts.dump", accData, 0666)
 		if err != nil {
@@ -351,7 +458,7 @@ func (s *Service) Export(dir string) error {
 		}
 	}
 
-	if s.payments != nil && len(s.payments) > 0 {
+	if len(repos.Payments.All()) > 0 {
 		payDir, err := filepath.Abs(dir)
 		if err != nil {
 			log.Print(err)
@@ -360,14 +467,11 @@ func (s *Service) Export(dir string) error {
 
 		payData := make([]byte, 0)
 
-		for _, payment := range s.payments {
-			str := string(payment.ID) + (";") +
-				strconv.FormatInt(int64(payment.AccountID), 10) + (";") +
-				strconv.FormatInt(int64(payment.Amount), 10) + (";") +
-				string(payment.Category) + (";") +
-				string(payment.Status) + ("\n")
-
-			payData = append(payData, []byte(str)...)
+		for _, payment := range repos.Payments.All() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			payData = append(payData, []byte(formatPayment(payment)+"\n")...)
 		}
 		err = os.WriteFile(payDir+"/payments.dump", payData, 0666)
 		if err != nil {
@@ -376,7 +480,7 @@ func (s *Service) Export(dir string) error {
 		}
 	}
 
-	if s.favorites != nil && len(s.favorites) > 0 {
+	if len(repos.Favorites.All()) > 0 || len(s.deletedFavorites) > 0 {
 		favDir, err := filepath.Abs(dir)
 		if err != nil {
 			log.Print(err)
@@ -385,29 +489,76 @@ func (s *Service) Export(dir string) error {
 
 		favData := make([]byte, 0)
 
-		for _, favorite := range s.favorites {
-			str := string(favorite.ID) + (";") +
-				strconv.FormatInt(int64(favorite.AccountID), 10) + (";") +
-				string(favorite.Name) + (";") +
-				strconv.FormatInt(int64(favorite.Amount), 10) + (";") +
-				string(favorite.Category) + ("\n")
-
-			favData = append(favData, []byte(str)...)
+		for _, favorite := range repos.Favorites.All() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			favData = append(favData, []byte(formatFavorite(favorite)+"\n")...)
 		}
 		err = os.WriteFile(favDir+"/favorites.dump", favData, 0666)
 		if err != nil {
 			log.Print(err)
 			return err
 		}
+		err = s.exportDeletedFavorites(favDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	trnDir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	for _, export := range []func(dir string) error{
+		s.exportTransfers,
+		s.exportLedger,
+		s.exportDeposits,
+		s.exportRefunds,
+		s.exportIdempotency,
+		s.exportLimits,
+		s.exportHolds,
+		s.exportFees,
+		s.exportRewards,
+		s.exportCategories,
+		s.exportBudgets,
+		s.exportSchedules,
+	} {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err = export(trnDir)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-//Import импортирует данные из accounts.dump, payments.dump and favorites.dump
+//Import импортирует данные из accounts.dump, payments.dump, favorites.dump, favorites_deleted.dump,
+//transfers.dump, deposits.dump,
+//refunds.dump, idempotency.dump, limits.dump, holds.dump, fees.dump, rewards.dump,
+//categories.dump, budgets.dump, schedules.dump, schedule_runs.dump and ledger.dump.
+//Балансы, для которых в журнале нет истории, проводятся как входящие остатки.
+//Избранные из favorites_deleted.dump удаляются, даже если были созданы до импорта.
 
-func (s *Service) Import(dir string) error {
-	dir, err := filepath.Abs(dir)
+func (s *Service) Import(dir string, opts ...CallOption) error {
+	return s.ImportContext(context.Background(), dir, opts...)
+}
+
+//ImportContext импортирует данные как Import и прекращает работу при отмене ctx,
+//возвращая ctx.Err(). Как и при ошибке в дампе, уже загруженные записи остаются в сервисе.
+func (s *Service) ImportContext(ctx context.Context, dir string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	dir, err = filepath.Abs(dir)
 	if err != nil {
 		log.Print(err)
 		return err
@@ -417,6 +568,19 @@ func (s *Service) Import(dir string) error {
 		return err
 	}
 
+	err = s.importLedger(dir)
+	if err != nil {
+		return err
+	}
+
+	err = s.importCategories(dir)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	accFile, err1 := os.ReadFile(dir + "/accounts.dump")
 	if err1 == nil {
 
@@ -425,38 +589,38 @@ func (s *Service) Import(dir string) error {
 		accSlice := strings.Split(accData, "\n")
 		log.Print("accounts : ", accSlice)
 
-		for _, accOperation := range accSlice {
+		for i, accOperation := range accSlice {
 
 			if len(accOperation) == 0 {
 				break
 			}
-			accStr := strings.Split(accOperation, ";")
-			log.Println("accStr:", accStr)
-
-			id, err := strconv.ParseInt(accStr[0], 10, 64)
-			if err != nil {
-				log.Print(err)
+			if err := ctx.Err(); err != nil {
 				return err
 			}
-			phone := types.Phone(accStr[1])
-			balance, err := strconv.ParseInt(accStr[2], 10, 64)
+			account, err := parseAccount(dir+"/accounts.dump", i+1, accOperation)
 			if err != nil {
 				log.Print(err)
 				return err
 			}
 
-			accFind, _ := s.FindAccountByID(id)
+			accFind, _ := s.findAccountByID(account.ID)
 			if accFind != nil {
-				accFind.Phone = phone
-				accFind.Balance = types.Money(balance)
+				s.setAccountPhone(accFind, account.Phone)
+				s.setBalance(accFind, account.Balance)
+				accFind.OverdraftLimit = account.OverdraftLimit
+				accFind.Tier = account.Tier
+				if !account.Created.IsZero() {
+					accFind.Created = account.Created
+					accFind.Updated = account.Updated
+				}
 			} else {
-				s.nextAccountID++
-				account := &types.Account{
-					ID:      id,
-					Phone:   phone,
-					Balance: types.Money(balance),
+				if account.ID > s.nextAccountID {
+					s.nextAccountID = account.ID
 				}
-				s.accounts = append(s.accounts, account)
+				balance := account.Balance
+				account.Balance = 0
+				s.insertAccount(account)
+				s.setBalance(account, balance)
 				log.Print(account)
 			}
 		}
@@ -472,43 +636,36 @@ func (s *Service) Import(dir string) error {
 		paySlice := strings.Split(payData, "\n")
 		log.Print("paySlice : ", paySlice)
 
-		for _, payOperation := range paySlice {
+		for i, payOperation := range paySlice {
 
 			if len(payOperation) == 0 {
 				break
 			}
-			payStr := strings.Split(payOperation, ";")
-			log.Println("payStr:", payStr)
-
-			id := payStr[0]
-			accountID, err := strconv.ParseInt(payStr[1], 10, 64)
-			if err != nil {
-				log.Print(err)
+			if err := ctx.Err(); err != nil {
 				return err
 			}
-			amount, err := strconv.ParseInt(payStr[2], 10, 64)
+			payment, err := parsePayment(dir+"/payments.dump", i+1, payOperation)
 			if err != nil {
 				log.Print(err)
 				return err
 			}
-			category := types.PaymentCategory(payStr[3])
-			status := types.PaymentStatus(payStr[4])
 
-			payAcc, _ := s.FindPaymentByID(id)
+			payAcc, _ := s.findPaymentByID(payment.ID)
 			if payAcc != nil {
-				payAcc.AccountID = accountID
-				payAcc.Amount = types.Money(amount)
-				payAcc.Category = category
-				payAcc.Status = status
-			} else {
-				payment := &types.Payment{
-					ID:        id,
-					AccountID: accountID,
-					Amount:    types.Money(amount),
-					Category:  category,
-					Status:    status,
+				s.setPaymentAccount(payAcc, payment.AccountID)
+				payAcc.Amount = payment.Amount
+				payAcc.Category = payment.Category
+				payAcc.Status = payment.Status
+				payAcc.Refunded = payment.Refunded
+				payAcc.History = payment.History
+				s.setPaymentFavorite(payAcc, payment.FavoriteID)
+				payAcc.Note = payment.Note
+				if !payment.Created.IsZero() {
+					s.setPaymentCreated(payAcc, payment.Created)
+					payAcc.Updated = payment.Updated
 				}
-				s.payments = append(s.payments, payment)
+			} else {
+				s.insertPayment(payment)
 				log.Print(payment)
 			}
 		}
@@ -516,6 +673,11 @@ func (s *Service) Import(dir string) error {
 		log.Print(err2)
 	}
 
+	err = s.importDeletedFavorites(dir)
+	if err != nil {
+		return err
+	}
+
 	favFile, err3 := os.ReadFile(dir + "/favorites.dump")
 	if err3 == nil {
 
@@ -524,43 +686,37 @@ func (s *Service) Import(dir string) error {
 		favSlice := strings.Split(favData, "\n")
 		log.Print("favSlice : ", favSlice)
 
-		for _, favOperation := range favSlice {
+		for i, favOperation := range favSlice {
 
 			if len(favOperation) == 0 {
 				break
 			}
-			favStr := strings.Split(favOperation, ";")
-			log.Println("favStr:", favStr)
-
-			id := favStr[0]
-			accountID, err := strconv.ParseInt(favStr[1], 10, 64)
-			if err != nil {
-				log.Print(err)
+			if err := ctx.Err(); err != nil {
 				return err
 			}
-			name := favStr[2]
-			amount, err := strconv.ParseInt(favStr[3], 10, 64)
+			favorite, err := parseFavorite(dir+"/favorites.dump", i+1, favOperation)
 			if err != nil {
 				log.Print(err)
 				return err
 			}
-			category := types.PaymentCategory(favStr[4])
 
-			favAcc, _ := s.GetFavoriteByID(id)
+			if _, ok := s.deletedFavorites[favorite.ID]; ok {
+				continue
+			}
+
+			favAcc, _ := s.getFavoriteByID(favorite.ID)
 			if favAcc != nil {
-				favAcc.AccountID = accountID
-				favAcc.Name = name
-				favAcc.Amount = types.Money(amount)
-				favAcc.Category = category
-			} else {
-				favorite := &types.Favorite{
-					ID:        id,
-					AccountID: accountID,
-					Name:      name,
-					Amount:    types.Money(amount),
-					Category:  category,
+				s.setFavoriteAccount(favAcc, favorite.AccountID)
+				favAcc.Name = favorite.Name
+				favAcc.Amount = favorite.Amount
+				favAcc.Category = favorite.Category
+				favAcc.Uses = favorite.Uses
+				if !favorite.Created.IsZero() {
+					favAcc.Created = favorite.Created
+					favAcc.Updated = favorite.Updated
 				}
-				s.favorites = append(s.favorites, favorite)
+			} else {
+				s.insertFavorite(favorite)
 				log.Print(favorite)
 			}
 		}
@@ -568,66 +724,76 @@ func (s *Service) Import(dir string) error {
 		log.Println(err3)
 	}
 
+	for _, load := range []func(dir string) error{
+		s.importTransfers,
+		s.importDeposits,
+		s.importRefunds,
+		s.importIdempotency,
+		s.importLimits,
+		s.importHolds,
+		s.importFees,
+		s.importRewards,
+		s.importBudgets,
+		s.importSchedules,
+	} {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err = load(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.record("IMPORT", "dump:"+dir, s.importSummary())
 	return nil
 
 }
 
 // SumPayments суммирует платежы
 func (s *Service) SumPayments(goroutines int) types.Money {
+	sum, _ := s.SumPaymentsContext(context.Background(), goroutines)
+	return sum
+}
 
-	if goroutines < 1 {
-		goroutines = 1
-	}
+//SumPaymentsContext суммирует платежи в goroutines горутинах.
+//При отмене ctx горутины останавливаются и возвращается ctx.Err().
+func (s *Service) SumPaymentsContext(ctx context.Context, goroutines int) (types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
+	totals := make([]types.Money, partsCount(goroutines))
+	err := s.visitPayments(ctx, len(totals), func(part int, payment *types.Payment) {
+		totals[part] += payment.Amount
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	num := len(s.payments)/goroutines + 1
 	sum := types.Money(0)
-
-	for i := 0; i < goroutines; i++ {
-
-		wg.Add(1)
-		total := types.Money(0)
-
-		func(val int) {
-			defer wg.Done()
-			lowIndex := val * num
-			highIndex := (val * num) + num
-
-			for j := lowIndex; j < highIndex; j++ {
-				if j > len(s.payments)-1 {
-					break
-				}
-				total += s.payments[j].Amount
-			}
-			mu.Lock()
-			defer mu.Unlock()
-			sum += total
-		}(i)
+	for _, total := range totals {
+		sum += total
 	}
-
-	wg.Wait()
-	return sum
+	return sum, nil
 }
 
 //ExportAccountHistory вытаскывает все платежи конктретного акаунта.
 func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	_, err := s.FindAccountByID(accountID)
+	_, err := s.findAccountByID(accountID)
 	if err != nil {
-		return nil, ErrAccountNotFound
+		return nil, err
 	}
 
 	payments := []types.Payment{}
-	for _, payment := range s.payments {
-		if payment.AccountID == accountID {
-			payments = append(payments, *payment)
-		}
+	for _, payment := range s.repositories().Payments.ByAccount(accountID) {
+		payments = append(payments, *payment)
 	}
 
 	if len(payments) <= 0 || payments == nil {
-		return nil, ErrPaymentNotFound
+		return nil, &Error{Err: ErrPaymentNotFound, AccountID: accountID}
 	}
 
 	return payments, nil
@@ -635,6 +801,15 @@ func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error)
 
 //HistoryToFiles сохранение всех данных в файл.
 func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records int) error {
+	return s.HistoryToFilesContext(context.Background(), payments, dir, records)
+}
+
+//HistoryToFilesContext сохраняет платежи как HistoryToFiles и прекращает работу
+//при отмене ctx, возвращая ctx.Err(). Уже записанные файлы остаются.
+func (s *Service) HistoryToFilesContext(ctx context.Context, payments []types.Payment, dir string, records int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	_, cerr := os.Stat(dir)
 	if os.IsNotExist(cerr) {
@@ -652,6 +827,9 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 
 	if len(payments) > 0 && len(payments) <= records {
 		for _, payment := range payments {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			text := []byte(
 				string(payment.ID) + ";" +
 					strconv.FormatInt(int64(payment.AccountID), 10) + ";" +
@@ -670,6 +848,9 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 		}
 	} else {
 		for i, payment := range payments {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 
 			text := []byte(
 				string(payment.ID) + ";" +
@@ -697,107 +878,130 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 
 //FilterPayments отфилтровывает плотежи по accountID.
 func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
+	return s.FilterPaymentsContext(context.Background(), accountID, goroutines)
+}
 
-	_, err := s.FindAccountByID(accountID)
+//FilterPaymentsContext отбирает платежи аккаунта как FilterPayments.
+//При отмене ctx горутины останавливаются и возвращается ctx.Err().
+func (s *Service) FilterPaymentsContext(ctx context.Context, accountID int64, goroutines int) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
 	if err != nil {
 		return nil, err
 	}
 
-	if goroutines < 1 {
-		goroutines = 1
-	}
-
-	num := len(s.payments)/goroutines + 1
-
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
-
-	payments := []types.Payment{}
-
-	for i := 0; i < goroutines; i++ {
-
-		wg.Add(1)
-		partOfPayment := []types.Payment{}
-
-		go func(val int) {
-			defer wg.Done()
-			lowIndex := val * num
-			highIndex := (val * num) + num
-
-			for j := lowIndex; j < highIndex; j++ {
-				if j > len(s.payments)-1 {
-					break
-				}
-				if s.payments[j].AccountID == accountID {
-					partOfPayment = append(partOfPayment, *s.payments[j])
-				}
-			}
-			mu.Lock()
-			defer mu.Unlock()
-			payments = append(payments, partOfPayment...)
-		}(i)
-	}
-
-	wg.Wait()
-	return payments, nil
+	return s.filterPayments(ctx, func(payment types.Payment) bool {
+		return payment.AccountID == accountID
+	}, goroutines)
 }
 
 //FilterPaymentsByFn - filters out payments by any function.
 func (s *Service) FilterPaymentsByFn(
 	filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
+	return s.FilterPaymentsByFnContext(context.Background(), filter, goroutines)
+}
 
-	if goroutines < 1 {
-		goroutines = 1
-	}
+//FilterPaymentsByFnContext отбирает платежи функцией filter как FilterPaymentsByFn.
+//При отмене ctx горутины останавливаются и возвращается ctx.Err().
+func (s *Service) FilterPaymentsByFnContext(
+	ctx context.Context, filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	num := len(s.payments)/goroutines + 1
+	return s.filterPayments(ctx, filter, goroutines)
+}
 
-	wg := sync.WaitGroup{}
-	mu := sync.Mutex{}
+//filterPayments отбирает платежи в goroutines горутинах, вызывающий должен держать s.mu на чтение
+func (s *Service) filterPayments(
+	ctx context.Context, filter func(payment types.Payment) bool, goroutines int) ([]types.Payment, error) {
+	parts := make([][]types.Payment, partsCount(goroutines))
+	err := s.visitPayments(ctx, len(parts), func(part int, payment *types.Payment) {
+		if filter(*payment) {
+			parts[part] = append(parts[part], *payment)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	payments := []types.Payment{}
+	for _, part := range parts {
+		payments = append(payments, part...)
+	}
+	return payments, nil
+}
 
-	for i := 0; i < goroutines; i++ {
+//visitPayments делит платежи на parts частей и обходит каждую в своей горутине,
+//вызывая visit с номером части. Вызывающий должен держать s.mu на чтение.
+//При отмене ctx обход прекращается, после остановки всех горутин возвращается ctx.Err().
+func (s *Service) visitPayments(ctx context.Context, parts int, visit func(part int, payment *types.Payment)) error {
+	all := s.repositories().Payments.All()
+	num := len(all)/parts + 1
+	done := ctx.Done()
 
-		wg.Add(1)
-		partOfPayment := []types.Payment{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < parts; i++ {
+		lowIndex := i * num
+		if lowIndex >= len(all) {
+			break
+		}
+		highIndex := lowIndex + num
+		if highIndex > len(all) {
+			highIndex = len(all)
+		}
 
-		go func(val int) {
+		wg.Add(1)
+		go func(part int, payments []*types.Payment) {
 			defer wg.Done()
-			lowIndex := val * num
-			highIndex := (val * num) + num
-
-			for j := lowIndex; j < highIndex; j++ {
-				if j > len(s.payments)-1 {
-					break
-				}
-				if filter(*s.payments[j]) {
-					partOfPayment = append(partOfPayment, *s.payments[j])
+			for _, payment := range payments {
+				select {
+				case <-done:
+					return
+				default:
 				}
+				visit(part, payment)
 			}
-			mu.Lock()
-			defer mu.Unlock()
-			payments = append(payments, partOfPayment...)
-		}(i)
+		}(i, all[lowIndex:highIndex])
 	}
 
 	wg.Wait()
-	return payments, nil
+	return ctx.Err()
 }
 
+func partsCount(goroutines int) int {
+	if goroutines < 1 {
+		return 1
+	}
+	return goroutines
+}
+
+//FilterCategory отбирает платежи категории "bank".
+//
+//Deprecated: используйте CategoryFilter, который учитывает справочник категорий.
 func FilterCategory(payment types.Payment) bool {
 	return payment.Category == "bank"
 }
 
 //SumPaymentsWithProgress разделяет в соответствие с заданным размером и суммирует все платежи в отдельных горутинах
 func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
+	return s.SumPaymentsWithProgressContext(context.Background())
+}
+
+//SumPaymentsWithProgressContext суммирует платежи как SumPaymentsWithProgress.
+//При отмене ctx все горутины завершаются, а канал закрывается, даже если его
+//больше никто не читает. Если после закрытия канала ctx.Err() не nil, сумма неполная.
+func (s *Service) SumPaymentsWithProgressContext(ctx context.Context) <-chan types.Progress {
 
 	size := 100_000
 
+	s.mu.RLock()
 	data := []types.Money{0}
-	for _, payment := range s.payments {
+	for _, payment := range s.repositories().Payments.All() {
 		data = append(data, payment.Amount)
 	}
+	s.mu.RUnlock()
 
 	goroutines := 1 + len(data)/size
 
@@ -823,18 +1027,29 @@ func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 			for _, v := range data {
 				sum += v
 			}
-			ch <- types.Progress{
+			select {
+			case ch <- types.Progress{
 				Part:   len(data),
 				Result: sum,
+			}:
+			case <-ctx.Done():
 			}
 		}(ch, data[lowIndex:highIndex])
 		channels[i] = ch
 	}
-	return Merge(channels)
+	return MergeContext(ctx, channels)
 }
 
 //Merge возвращает канал с сообщении из всех переданных каналов
 func Merge(channels []<-chan types.Progress) <-chan types.Progress {
+	return MergeContext(context.Background(), channels)
+}
+
+//MergeContext возвращает канал с сообщениями из всех переданных каналов.
+//Канал закрывается, когда закрыты все исходные каналы или отменён ctx.
+//После отмены оставшиеся сообщения исходных каналов читаются и отбрасываются,
+//чтобы отправители не зависли, если их никто больше не слушает.
+func MergeContext(ctx context.Context, channels []<-chan types.Progress) <-chan types.Progress {
 	wg := sync.WaitGroup{}
 	wg.Add(len(channels))
 
@@ -842,9 +1057,9 @@ func Merge(channels []<-chan types.Progress) <-chan types.Progress {
 
 	for _, ch := range channels {
 		go func(ch <-chan types.Progress) {
-			defer wg.Done()
-			for val := range ch {
-				merged <- val
+			forwardProgress(ctx, ch, merged)
+			wg.Done()
+			for range ch {
 			}
 		}(ch)
 	}
@@ -854,3 +1069,22 @@ func Merge(channels []<-chan types.Progress) <-chan types.Progress {
 	}()
 	return merged
 }
+
+//forwardProgress пересылает сообщения из ch в merged, пока ch не закрыт и ctx не отменён
+func forwardProgress(ctx context.Context, ch <-chan types.Progress, merged chan<- types.Progress) {
+	for {
+		select {
+		case val, ok := <-ch:
+			if !ok {
+				return
+			}
+			select {
+			case merged <- val:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
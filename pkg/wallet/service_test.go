@@ -1,9 +1,15 @@
 package wallet
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/rgsgit/wallet/pkg/types"
@@ -11,6 +17,29 @@ import (
 
 type testService struct {
 	*Service
+	clock *fakeClock
+}
+
+//fakeClock часы для тестов, время двигается только вызовом Add
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1_600_000_000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
 }
 
 type testAccount struct {
@@ -45,7 +74,8 @@ var defaultTestAccount = testAccount{
 }
 
 func newTestService() *testService {
-	return &testService{Service: &Service{}}
+	clock := newFakeClock()
+	return &testService{Service: &Service{clock: clock}, clock: clock}
 }
 
 func (s *testService) addAccountWithBalance(phone types.Phone, balance types.Money) (*types.Account, error) {
@@ -59,7 +89,27 @@ func (s *testService) addAccountWithBalance(phone types.Phone, balance types.Mon
 		return nil, fmt.Errorf("can't deposit account, error = %v", err)
 	}
 
-	return account, nil
+	return s.FindAccountByID(account.ID)
+}
+
+//account возвращает текущее состояние аккаунта
+func (s *testService) account(t *testing.T, accountID int64) *types.Account {
+	t.Helper()
+	account, err := s.FindAccountByID(accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+//payment возвращает текущее состояние платежа
+func (s *testService) payment(t *testing.T, paymentID string) *types.Payment {
+	t.Helper()
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payment
 }
 
 func (s *testService) addAccount(data testAccount) (*types.Account, []*types.Payment, error) {
@@ -81,6 +131,10 @@ func (s *testService) addAccount(data testAccount) (*types.Account, []*types.Pay
 		}
 	}
 
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		return nil, nil, err
+	}
 	return account, payments, nil
 }
 
@@ -258,7 +312,7 @@ func TestService_Reject_notFound(t *testing.T) {
 		t.Errorf("Error: %v", err)
 	}
 	_, err = service.FindPaymentByID(uuid.New().String())
-	if err != ErrPaymentNotFound {
+	if !errors.Is(err, ErrPaymentNotFound) {
 		t.Errorf("FindPaymentByID(): wrong must return ErrPaymentNotFound, returned = %v", err)
 
 	}
@@ -474,6 +528,34 @@ func TestService_ImportFromFile_success(t *testing.T) {
 		return
 	}
 }
+
+func TestService_ImportFromFile_nextAccountID(t *testing.T) {
+	s := newTestService()
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	err := os.WriteFile(path, []byte("1;+992000000001;100|3;+992000000003;300|1;+992000000011;150|"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ImportFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	account := s.account(t, 1)
+	if account.Phone != "+992000000011" || account.Balance != 150 {
+		t.Errorf("ImportFromFile(): duplicate must be merged, account = %v", account)
+	}
+	next, err := s.RegisterAccount("+992000000004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != 4 {
+		t.Errorf("RegisterAccount(): ID = %v, want %v", next.ID, 4)
+	}
+	if err := s.VerifyLedger(); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestService_ImportFromFile_noSuccess(t *testing.T) {
 	s := newTestService()
 
@@ -493,7 +575,7 @@ func TestService_Export_success(t *testing.T) {
 	}
 	Transactions(s)
 
-	err = s.Export("../../data")
+	err = s.Export(t.TempDir())
 	if err != nil {
 		t.Error(err)
 		return
@@ -552,7 +634,6 @@ func BenchmarkSumPayments(b *testing.B) {
 	}
 }
 
-
 func TestService_ExportAccountHistory_success(t *testing.T) {
 	s := newTestService()
 	Transactions(s)
@@ -590,7 +671,7 @@ func TestService_ExportAccountHistory_notSuccess2(t *testing.T) {
 	if err == nil {
 		t.Error(err)
 	}
-	if err != ErrAccountNotFound {
+	if !errors.Is(err, ErrAccountNotFound) {
 		t.Errorf("ExportAccountHistory(): must return ErrAccountNotFound, returned = %v", err)
 		return
 	}
@@ -605,7 +686,7 @@ func TestService_HistoryToFiles_success1(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	err = s.HistoryToFiles(payments, "data", 3)
+	err = s.HistoryToFiles(payments, t.TempDir(), 3)
 	if err != nil {
 		t.Error(err)
 	}
@@ -618,7 +699,7 @@ func TestService_HistoryToFiles_Success2(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	err = s.HistoryToFiles(payments, "data", 12)
+	err = s.HistoryToFiles(payments, t.TempDir(), 12)
 	if err != nil {
 		t.Error(err)
 	}
@@ -629,7 +710,7 @@ func TestService_HistoryToFiles_notSuccess1(t *testing.T) {
 	Transactions(s)
 
 	payment := []types.Payment{}
-	err := s.HistoryToFiles(payment, "data", 12)
+	err := s.HistoryToFiles(payment, t.TempDir(), 12)
 	if err != nil {
 		t.Error(err)
 	}
@@ -734,3 +815,194 @@ func BenchmarkFilterPaymentsByFn(b *testing.B) {
 	}
 }
 
+func TestService_concurrentPayDepositReject(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			payment, err := s.Pay(account.ID, 10_00, "auto")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := s.Reject(payment.ID); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.Deposit(account.ID, 1_00); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.SumPayments(3)
+			if _, err := s.FilterPayments(account.ID, 3); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := types.Money(1_000_00 + 50*1_00)
+	if got.Balance != want {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", got.Balance, want)
+	}
+}
+
+func TestService_concurrentPayNotOverdraw(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	paid := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Pay(account.ID, 3_00, "auto")
+			if errors.Is(err, ErrNotEnoughBalance) {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			paid++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if paid != 33 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", paid, 33)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != 1_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, 1_00)
+	}
+}
+
+func TestService_concurrentPayReturnsCopies(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 1_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = s.Pay(account.ID, 1_00, "auto")
+		}()
+		go func() {
+			defer wg.Done()
+			//под -race чтение полей копий не должно пересекаться с post()
+			_ = account.Balance + payment.Amount
+			_ = s.account(t, account.ID).Balance
+		}()
+	}
+	wg.Wait()
+
+	account.Balance = 0
+	if s.account(t, account.ID).Balance != 89_00 {
+		t.Errorf("FindAccountByID(): returned account must be a copy")
+	}
+}
+
+func TestService_concurrentRegisterAccount(t *testing.T) {
+	s := newTestService()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.RegisterAccount("+992000000001")
+		}()
+		go func(i int) {
+			defer wg.Done()
+			s.RegisterAccount(types.Phone("+99200000010" + strconv.Itoa(i)))
+		}(i)
+	}
+	wg.Wait()
+
+	if s.nextAccountID != 21 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", s.nextAccountID, 21)
+	}
+}
+
+func TestService_concurrentFavoritesAndExport(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance("+992000000001", 1_000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 1_00, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			favorite, err := s.FavoritePayment(payment.ID, "auto"+strconv.Itoa(i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := s.PayFromFavorite(favorite.ID); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Repeat(payment.ID); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.Export(dir); err != nil {
+				t.Error(err)
+			}
+			for range s.SumPaymentsWithProgress() {
+			}
+		}()
+	}
+	wg.Wait()
+
+	history, err := s.ExportAccountHistory(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 41 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", len(history), 41)
+	}
+}
@@ -0,0 +1,185 @@
+package wallet
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrInvalidTransition = errors.New("invalid payment status transition")
+
+//TransitionError подробности недопустимой смены статуса платежа. Сервис возвращает *Error
+//с ErrInvalidTransition, а TransitionError лежит в его Cause и достаётся через errors.As.
+type TransitionError struct {
+	PaymentID string
+	From      types.PaymentStatus
+	To        types.PaymentStatus
+}
+
+func (e *TransitionError) Error() string {
+	return "status " + string(e.From) + " -> " + string(e.To)
+}
+
+//Is позволяет сравнивать ошибку с ErrInvalidTransition
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+//paymentTransitions допустимые переходы между статусами платежа
+var paymentTransitions = map[types.PaymentStatus][]types.PaymentStatus{
+	types.PaymentStatusInProgress: {types.PaymentStatusOk, types.PaymentStatusFail},
+	types.PaymentStatusOk: {
+		types.PaymentStatusFail,
+		types.PaymentStatusPartiallyRefunded,
+		types.PaymentStatusRefunded,
+	},
+	types.PaymentStatusPartiallyRefunded: {
+		types.PaymentStatusFail,
+		types.PaymentStatusPartiallyRefunded,
+		types.PaymentStatusRefunded,
+	},
+}
+
+//transitionError ошибка перевода платежа payment в статус to
+func transitionError(payment *types.Payment, to types.PaymentStatus) error {
+	return &Error{
+		Err:       ErrInvalidTransition,
+		AccountID: payment.AccountID,
+		ID:        payment.ID,
+		Cause:     &TransitionError{PaymentID: payment.ID, From: payment.Status, To: to},
+	}
+}
+
+//CanTransition проверяет, можно ли перевести платёж из статуса from в статус to
+func CanTransition(from types.PaymentStatus, to types.PaymentStatus) bool {
+	for _, status := range paymentTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+//transition меняет статус платежа и записывает смену в историю
+func (s *Service) transition(payment *types.Payment, to types.PaymentStatus) error {
+	if !CanTransition(payment.Status, to) {
+		return transitionError(payment, to)
+	}
+
+	now := s.now()
+	payment.History = append(payment.History, types.StatusChange{From: payment.Status, To: to, At: now})
+	payment.Status = to
+	payment.Updated = now
+	s.dirty.payments = true
+	return nil
+}
+
+//completePayment переводит платёж в OK и начисляет вознаграждения
+func (s *Service) completePayment(payment *types.Payment) error {
+	err := s.transition(payment, types.PaymentStatusOk)
+	if err != nil {
+		return err
+	}
+	s.accrueRewards(payment)
+	s.publish(types.Event{
+		Type:      types.EventPaymentCompleted,
+		AccountID: payment.AccountID,
+		EntityID:  payment.ID,
+		Amount:    payment.Amount,
+	})
+	return nil
+}
+
+//failPayment переводит платёж в FAIL и возвращает на счёт то, что ещё не было возвращено
+func (s *Service) failPayment(payment *types.Payment) error {
+	acc, err := s.findAccountByID(payment.AccountID)
+	if err != nil {
+		return err
+	}
+
+	err = s.transition(payment, types.PaymentStatusFail)
+	if err != nil {
+		return err
+	}
+	amount := payment.Amount - payment.Refunded
+	if amount > 0 {
+		s.post(payment.ID, LedgerPaymentsAccountID, acc.ID, amount)
+	}
+	s.refundFee(payment, payment.Amount)
+	s.reverseRewards(payment, payment.Amount)
+	s.publish(types.Event{
+		Type:      types.EventPaymentRejected,
+		AccountID: acc.ID,
+		EntityID:  payment.ID,
+		Amount:    amount,
+	})
+
+	return nil
+}
+
+//Complete подтверждает проведение платежа: INPROGRESS -> OK и начисляет вознаграждения
+func (s *Service) Complete(paymentID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+
+	return s.completePayment(payment)
+}
+
+//Fail отмечает платёж как не прошедший и возвращает деньги: INPROGRESS -> FAIL
+func (s *Service) Fail(paymentID string, opts ...CallOption) (err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+
+	if payment.Status != types.PaymentStatusInProgress {
+		return transitionError(payment, types.PaymentStatusFail)
+	}
+
+	return s.failPayment(payment)
+}
+
+//formatHistory записывает историю статусов для dump: INPROGRESS>OK@время,OK>FAIL@время
+func formatHistory(history []types.StatusChange) string {
+	changes := make([]string, len(history))
+	for i, change := range history {
+		changes[i] = string(change.From) + ">" + string(change.To) + "@" + formatTime(change.At)
+	}
+	return strings.Join(changes, ",")
+}
+
+//parseHistory читает историю статусов, записанную formatHistory
+func parseHistory(str string) []types.StatusChange {
+	if str == "" {
+		return nil
+	}
+
+	var history []types.StatusChange
+	for _, change := range strings.Split(str, ",") {
+		var at time.Time
+		if i := strings.LastIndex(change, "@"); i >= 0 {
+			at, _ = parseTime(change[i+1:])
+			change = change[:i]
+		}
+		statuses := strings.SplitN(change, ">", 2)
+		if len(statuses) != 2 {
+			continue
+		}
+		history = append(history, types.StatusChange{
+			From: types.PaymentStatus(statuses[0]),
+			To:   types.PaymentStatus(statuses[1]),
+			At:   at,
+		})
+	}
+	return history
+}
@@ -0,0 +1,148 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from types.PaymentStatus
+		to   types.PaymentStatus
+		want bool
+	}{
+		{from: types.PaymentStatusInProgress, to: types.PaymentStatusOk, want: true},
+		{from: types.PaymentStatusInProgress, to: types.PaymentStatusFail, want: true},
+		{from: types.PaymentStatusOk, to: types.PaymentStatusFail, want: true},
+		{from: types.PaymentStatusOk, to: types.PaymentStatusInProgress, want: false},
+		{from: types.PaymentStatusOk, to: types.PaymentStatusOk, want: false},
+		{from: types.PaymentStatusFail, to: types.PaymentStatusOk, want: false},
+		{from: types.PaymentStatusFail, to: types.PaymentStatusInProgress, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%v, %v): result_we_got %v, result_we_want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestService_Complete_success(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := payments[0]
+
+	if err := s.Complete(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	payment = s.payment(t, payment.ID)
+	if payment.Status != types.PaymentStatusOk {
+		t.Errorf("Complete(): wrong status = %v", payment.Status)
+	}
+
+	err = s.Complete(payment.ID)
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) || !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Complete(): must return TransitionError, returned = %v", err)
+	}
+	if transitionErr.From != types.PaymentStatusOk || transitionErr.To != types.PaymentStatusOk {
+		t.Errorf("Complete(): wrong error = %v", transitionErr)
+	}
+}
+
+func TestService_Fail(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance := account.Balance
+
+	if err := s.Fail(payments[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	account = s.account(t, account.ID)
+	if account.Balance != balance+payments[0].Amount {
+		t.Errorf("Fail(): balance didn't change = %v", account.Balance)
+	}
+
+	if err := s.Complete(payments[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Fail(payments[1].ID); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Fail(): must return ErrInvalidTransition, returned = %v", err)
+	}
+	if err := s.Fail(payments[0].ID); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Fail(): must return ErrInvalidTransition, returned = %v", err)
+	}
+	if err := s.Fail("unknown"); !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("Fail(): must return ErrPaymentNotFound, returned = %v", err)
+	}
+}
+
+func TestService_Reject_completed(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := payments[0]
+
+	if err := s.Complete(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reject(payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Complete(payment.ID); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Complete(): must return ErrInvalidTransition, returned = %v", err)
+	}
+
+	want := []types.StatusChange{
+		{From: types.PaymentStatusInProgress, To: types.PaymentStatusOk, At: s.clock.Now()},
+		{From: types.PaymentStatusOk, To: types.PaymentStatusFail, At: s.clock.Now()},
+	}
+	payment = s.payment(t, payment.ID)
+	if !reflect.DeepEqual(payment.History, want) {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", payment.History, want)
+	}
+}
+
+func TestService_Export_history(t *testing.T) {
+	s := newTestService()
+	_, payments, err := s.addAccount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Complete(payments[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reject(payments[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payment := range payments {
+		got, err := imported.FindPaymentByID(payment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, s.payment(t, payment.ID)) {
+			t.Errorf("FindPaymentByID(): wrong payment returned = %v", got)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package wallet
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+var ErrTransferNotFound = errors.New("transfer not found")
+
+//Transfer переводит деньги со счёта fromID на счёт toID.
+//Списание и зачисление происходят атомарно, перевод виден в истории обоих счетов.
+func (s *Service) Transfer(fromID int64, toID int64, amount types.Money, opts ...CallOption) (_ *types.Transfer, err error) {
+	s.lock(opts)
+	defer s.unlockInto(&err)
+
+	return copyTransfer(s.transfer(fromID, toID, amount))
+}
+
+func (s *Service) transfer(fromID int64, toID int64, amount types.Money) (*types.Transfer, error) {
+	if amount <= 0 {
+		return nil, &Error{Err: ErrAmmountMustBePositive, AccountID: fromID, Amount: amount}
+	}
+
+	if fromID == toID {
+		return nil, &Error{Err: ErrSelfTransfer, AccountID: fromID}
+	}
+
+	from, err := s.findAccountByID(fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := s.findAccountByID(toID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.canDebit(from, amount) {
+		return nil, &Error{Err: ErrNotEnoughBalance, AccountID: from.ID, Amount: amount}
+	}
+
+	now := s.now()
+	transfer := &types.Transfer{
+		ID:            uuid.New().String(),
+		FromAccountID: fromID,
+		ToAccountID:   toID,
+		Amount:        amount,
+		Status:        types.PaymentStatusOk,
+		Created:       now,
+		Updated:       now,
+	}
+	s.insertTransfer(transfer)
+	s.post(transfer.ID, from.ID, to.ID, amount)
+	s.publish(types.Event{
+		Type:             types.EventTransferCreated,
+		AccountID:        from.ID,
+		CounterAccountID: to.ID,
+		EntityID:         transfer.ID,
+		Amount:           amount,
+	})
+
+	return transfer, nil
+}
+
+//FindTransferByID поиск перевода по ID
+func (s *Service) FindTransferByID(transferID string) (*types.Transfer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return copyTransfer(s.findTransferByID(transferID))
+}
+
+func (s *Service) findTransferByID(transferID string) (*types.Transfer, error) {
+	transfer, ok := s.indexes().transfersByID[transferID]
+	if !ok {
+		return nil, &Error{Err: ErrTransferNotFound, ID: transferID}
+	}
+	return transfer, nil
+}
+
+//AccountTransfers возвращает входящие и исходящие переводы аккаунта
+func (s *Service) AccountTransfers(accountID int64) ([]types.Transfer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := []types.Transfer{}
+	for _, transfer := range s.indexes().transfersByAccount[accountID] {
+		transfers = append(transfers, *transfer)
+	}
+	return transfers, nil
+}
+
+//rejectTransfer возвращает деньги отправителю перевода
+func (s *Service) rejectTransfer(transfer *types.Transfer) error {
+	if transfer.Status == types.PaymentStatusFail {
+		return nil
+	}
+
+	from, err := s.findAccountByID(transfer.FromAccountID)
+	if err != nil {
+		return err
+	}
+
+	to, err := s.findAccountByID(transfer.ToAccountID)
+	if err != nil {
+		return err
+	}
+
+	if !s.canDebit(to, transfer.Amount) {
+		return &Error{Err: ErrNotEnoughBalance, AccountID: to.ID, ID: transfer.ID, Amount: transfer.Amount}
+	}
+
+	s.post(transfer.ID, to.ID, from.ID, transfer.Amount)
+	transfer.Status = types.PaymentStatusFail
+	transfer.Updated = s.now()
+	s.publish(types.Event{
+		Type:             types.EventTransferRejected,
+		AccountID:        from.ID,
+		CounterAccountID: to.ID,
+		EntityID:         transfer.ID,
+		Amount:           transfer.Amount,
+	})
+
+	return nil
+}
+
+//insertTransfer добавляет перевод в хранилище и индексы
+func (s *Service) insertTransfer(transfer *types.Transfer) {
+	idx := s.indexes()
+	s.transfers = append(s.transfers, transfer)
+	idx.transfersByID[transfer.ID] = transfer
+	idx.indexTransfer(transfer)
+}
+
+//indexTransfer добавляет перевод в историю обоих счетов
+func (idx *index) indexTransfer(transfer *types.Transfer) {
+	idx.transfersByAccount[transfer.FromAccountID] = append(idx.transfersByAccount[transfer.FromAccountID], transfer)
+	if transfer.ToAccountID != transfer.FromAccountID {
+		idx.transfersByAccount[transfer.ToAccountID] = append(idx.transfersByAccount[transfer.ToAccountID], transfer)
+	}
+}
+
+//unindexTransfer убирает перевод из истории обоих счетов
+func (idx *index) unindexTransfer(transfer *types.Transfer) {
+	for _, accountID := range []int64{transfer.FromAccountID, transfer.ToAccountID} {
+		transfers := idx.transfersByAccount[accountID]
+		for i, trn := range transfers {
+			if trn == transfer {
+				idx.transfersByAccount[accountID] = append(transfers[:i:i], transfers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+//exportTransfers записывает переводы в transfers.dump
+func (s *Service) exportTransfers(dir string) error {
+	if len(s.transfers) == 0 {
+		return nil
+	}
+
+	trnData := make([]byte, 0)
+
+	for _, transfer := range s.transfers {
+		str := transfer.ID + (";") +
+			strconv.FormatInt(transfer.FromAccountID, 10) + (";") +
+			strconv.FormatInt(transfer.ToAccountID, 10) + (";") +
+			strconv.FormatInt(int64(transfer.Amount), 10) + (";") +
+			string(transfer.Status) + (";") +
+			formatTime(transfer.Created) + (";") +
+			formatTime(transfer.Updated) + ("\n")
+
+		trnData = append(trnData, []byte(str)...)
+	}
+	err := os.WriteFile(dir+"/transfers.dump", trnData, 0666)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+//importTransfers читает переводы из transfers.dump
+func (s *Service) importTransfers(dir string) error {
+	path := dir + "/transfers.dump"
+	trnFile, err := os.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+
+	for i, trnOperation := range strings.Split(string(trnFile), "\n") {
+		if len(trnOperation) == 0 {
+			break
+		}
+		trnStr, err := dumpFields(path, i+1, trnOperation, 5)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+
+		id := trnStr[0]
+		fromID, err := strconv.ParseInt(trnStr[1], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		toID, err := strconv.ParseInt(trnStr[2], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		amount, err := strconv.ParseInt(trnStr[3], 10, 64)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+		status := types.PaymentStatus(trnStr[4])
+		created, updated, err := parseTimes(trnStr, 5)
+		if err != nil {
+			log.Print(err)
+			return dumpError(path, i+1, err)
+		}
+
+		trnFind, _ := s.findTransferByID(id)
+		if trnFind != nil {
+			idx := s.indexes()
+			idx.unindexTransfer(trnFind)
+			trnFind.FromAccountID = fromID
+			trnFind.ToAccountID = toID
+			trnFind.Amount = types.Money(amount)
+			trnFind.Status = status
+			trnFind.Created = created
+			trnFind.Updated = updated
+			idx.indexTransfer(trnFind)
+		} else {
+			s.insertTransfer(&types.Transfer{
+				ID:            id,
+				FromAccountID: fromID,
+				ToAccountID:   toID,
+				Amount:        types.Money(amount),
+				Status:        status,
+				Created:       created,
+				Updated:       updated,
+			})
+		}
+	}
+	return nil
+}
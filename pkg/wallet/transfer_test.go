@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rgsgit/wallet/pkg/types"
+)
+
+func TestService_Transfer_success(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992000000002", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.Transfer(from.ID, to.ID, 30_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to = s.account(t, from.ID), s.account(t, to.ID)
+	if from.Balance != 70_00 || to.Balance != 40_00 {
+		t.Errorf("Transfer(): wrong balances from = %v, to = %v", from.Balance, to.Balance)
+	}
+
+	for _, account := range []*types.Account{from, to} {
+		transfers, err := s.AccountTransfers(account.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(transfers) != 1 || !reflect.DeepEqual(transfers[0], *transfer) {
+			t.Errorf("AccountTransfers(): wrong transfers returned = %v", transfers)
+		}
+	}
+}
+
+func TestService_Transfer_notEnoughBalance(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992000000001", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992000000002", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Transfer(from.ID, to.ID, 30_00)
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Transfer(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	if from.Balance != 10_00 || to.Balance != 10_00 {
+		t.Errorf("Transfer(): balances must not change from = %v, to = %v", from.Balance, to.Balance)
+	}
+}
+
+func TestService_Transfer_notFound(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992000000001", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Transfer(from.ID, from.ID+1, 1_00)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Transfer(): must return ErrAccountNotFound, returned = %v", err)
+	}
+	if from.Balance != 10_00 {
+		t.Errorf("Transfer(): balance must not change = %v", from.Balance)
+	}
+}
+
+func TestService_Reject_transfer(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992000000002", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.Transfer(from.ID, to.ID, 30_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(transfer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to = s.account(t, from.ID), s.account(t, to.ID)
+	if from.Balance != 100_00 || to.Balance != 1 {
+		t.Errorf("Reject(): wrong balances from = %v, to = %v", from.Balance, to.Balance)
+	}
+	transfer, err = s.FindTransferByID(transfer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Status != types.PaymentStatusFail {
+		t.Errorf("Reject(): wrong status = %v", transfer.Status)
+	}
+}
+
+func TestService_Reject_transferSpent(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992000000002", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.Transfer(from.ID, to.ID, 30_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pay(to.ID, 20_00, "auto"); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(transfer.ID)
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Reject(): must return ErrNotEnoughBalance, returned = %v", err)
+	}
+	if transfer.Status != types.PaymentStatusOk {
+		t.Errorf("Reject(): wrong status = %v", transfer.Status)
+	}
+}
+
+func TestService_Transfer_exportImport(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance("+992000000001", 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992000000002", 10_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := s.Transfer(from.ID, to.ID, 30_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := newTestService()
+	if err := imported.Import(dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := imported.FindTransferByID(transfer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, transfer) {
+		t.Errorf("FindTransferByID(): wrong transfer returned = %v", got)
+	}
+
+	if err := imported.Reject(transfer.ID); err != nil {
+		t.Fatal(err)
+	}
+	account, err := imported.FindAccountByID(from.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 100_00 {
+		t.Errorf("INVALID: result_we_got %v, result_we_want %v", account.Balance, 100_00)
+	}
+	transfers, err := imported.AccountTransfers(to.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 || transfers[0].Status != types.PaymentStatusFail {
+		t.Errorf("AccountTransfers(): wrong transfers returned = %v", transfers)
+	}
+}